@@ -1,41 +1,87 @@
 package monk
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"path"
 	"runtime"
-	"strings"
 )
 
 // Get the asset specified by assetPath.
 func Get(assetPath string) (string, error) {
-	cache := NewContext(DiskFS{})
+	cache := newAssetsContext()
 
 	r := &Resolution{}
+	if err := r.Resolve(assetPath, cache); err != nil {
+		return "", err
+	}
+
+	return Build(r, cache)
+}
+
+// Returns a new Context that finds assets in the assets directory alongside this
+// package's source.
+func newAssetsContext() *Context {
+	cache := NewContext(DiskFS{})
 
 	_, filepath, _, _ := runtime.Caller(0)
 	dir := path.Join(path.Dir(filepath), "assets")
 
 	cache.SearchPath(dir)
+	return cache
+}
 
-	if err := r.Resolve(assetPath, cache); err != nil {
+// Build the assets in r, returning them concatenated together.
+func Build(r *Resolution, context *Context) (string, error) {
+	var out bytes.Buffer
+	if err := BuildTo(&out, r, context); err != nil {
 		return "", err
 	}
+	return out.String(), nil
+}
 
-	return Build(r, cache), nil
+// Build the assets in r, writing each of them to w in turn. When source maps are
+// enabled, the output refers to a source map named after the bundle with .map
+// appended, which can be generated using BuildSourceMap.
+func BuildTo(w io.Writer, r *Resolution, context *Context) error {
+	return buildTo(w, r, context, path.Base(r.root)+".map")
 }
 
-// TODO should return an error
-func Build(r *Resolution, context *Context) string {
-	contents := make([]string, len(r.Resolved))
+// Like BuildTo, but with the URL of the source map given explicitly. No source map
+// comment is written if sourceMapURL is empty.
+func buildTo(w io.Writer, r *Resolution, context *Context, sourceMapURL string) error {
+	headers := hasHeaders(r)
+
 	for _, logicalPath := range r.Resolved {
 		asset, err := context.lookup(logicalPath)
 		if err != nil {
-			panic(err)
+			return err
 		}
+		if !headers {
+			if _, err := io.WriteString(w, asset.Content); err != nil {
+				return err
+			}
+			continue
+		}
+
 		header := fmt.Sprintf("/* %s */\n", logicalPath)
-		contents = append(contents, header, asset.Content, "\n")
+		for _, s := range []string{header, asset.Content, "\n"} {
+			if _, err := io.WriteString(w, s); err != nil {
+				return err
+			}
+		}
+	}
+
+	if context.Config.SourceMaps && sourceMapURL != "" {
+		return writeSourceMappingURL(w, r, sourceMapURL)
 	}
+	return nil
+}
 
-	return strings.Join(contents, "")
+// Only bundles that support block comments have a header written before each
+// asset, so other assets such as images are written unchanged.
+func hasHeaders(r *Resolution) bool {
+	_, ext, _ := splitExtensions(r.root)
+	return commentSyntaxes[ext].blockStart == "/*"
 }
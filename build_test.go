@@ -1,6 +1,8 @@
 package monk
 
 import (
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -67,8 +69,102 @@ func TestBuild(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	built := Build(r, context)
+	built, err := Build(r, context)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if built != expected {
 		t.Errorf("expected %q, got: %q", expected, built)
 	}
 }
+
+func TestRequireSelf(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.css", "/*\n *= require reset\n *= require_self\n *= require theme\n */\n.app {}\n")
+	fs.File("assets/reset.css", "* { margin: 0 }\n")
+	fs.File("assets/theme.css", ".theme {}\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+
+	r := &Resolution{}
+	if err := r.Resolve("app.css", context); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"reset.css", "app.css", "theme.css"}
+	if !eq(r.Resolved, expected) {
+		t.Errorf("expected %v, got: %v", expected, r.Resolved)
+	}
+
+	built, err := Build(r, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "/* reset.css */\n* { margin: 0 }\n\n/* app.css */\n/*\n */\n.app {}\n\n/* theme.css */\n.theme {}\n\n"
+	if built != want {
+		t.Errorf("expected %q, got: %q", want, built)
+	}
+}
+
+func TestStub(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/vendor.js", "//= require jquery\n")
+	fs.File("assets/jquery.js", "//= require sizzle\njquery\n")
+	fs.File("assets/sizzle.js", "sizzle\n")
+	fs.File("assets/widgets.js", "//= require jquery\nwidgets\n")
+	fs.File("assets/util.js", "util\n")
+	fs.File("assets/app.js", "//= require util\n//= require sizzle\n//= stub vendor\n//= require widgets\napp\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+
+	r := &Resolution{}
+	if err := r.Resolve("app.js", context); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"util.js", "widgets.js", "app.js"}
+	if !eq(r.Resolved, expected) {
+		t.Errorf("expected %v, got: %v", expected, r.Resolved)
+	}
+
+	built, err := Build(r, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stubbed := range []string{"vendor.js", "jquery.js", "sizzle.js"} {
+		if strings.Contains(built, stubbed) {
+			t.Errorf("expected %q to be stubbed out of %q", stubbed, built)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestBuildErrors(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/a.js", "//= require b\n")
+	fs.File("assets/b.js", "b\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+
+	r := &Resolution{}
+	if err := r.Resolve("a.js", context); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := BuildTo(failingWriter{}, r, context); err == nil || err.Error() != "disk full" {
+		t.Errorf("expected write errors to be returned, got: %v", err)
+	}
+
+	r.Resolved = append(r.Resolved, "deleted.js")
+	if _, err := Build(r, context); err == nil {
+		t.Error("expected an error building a missing asset")
+	}
+}
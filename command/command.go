@@ -5,39 +5,53 @@ import (
 	"fmt"
 	"github.com/jim/monk"
 	"os"
-	"path"
-	"strings"
+	"sort"
+	"time"
 )
 
-type searchPaths []string
+var options monk.Options
 
-func (sp *searchPaths) Set(searchPath string) error {
-	if !strings.HasPrefix(searchPath, "/") {
-		dir, err := os.Getwd()
-		if err != nil {
-			return err
-		}
-		searchPath = path.Join(dir, searchPath)
-	}
-	*sp = append(*sp, searchPath)
-	return nil
-}
+var outputDirFlag string
 
-func (sp *searchPaths) String() string {
-	return strings.Join(*sp, ",")
-}
+var manifestNameFlag string
 
-var searchPathsFlag searchPaths
-
-var assetRootFlag string
+var keepFlag int
 
+var ageFlag time.Duration
 
 func init() {
-	flag.Var(&searchPathsFlag, "s", "path to search for assets when building")
-  flag.StringVar(&assetRootFlag, "r", "/assets/", "asset root used in compiled files")
+	options.AddFlags(flag.CommandLine)
+}
+
+// Registers the flags used to locate the output directory and manifest on fs.
+func addManifestFlags(fs *flag.FlagSet) {
+	fs.StringVar(&outputDirFlag, "o", "public/assets", "directory to write precompiled assets to")
+	fs.StringVar(&manifestNameFlag, "manifest", "", "name of the manifest file in the output directory (default .sprockets-manifest-<random hex>.json)")
+}
+
+func loadManifest() *monk.Manifest {
+	manifest, err := monk.LoadManifest(outputDirFlag, manifestNameFlag)
+	if err != nil {
+		panic(err)
+	}
+	return manifest
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "precompile":
+			precompile(os.Args[2:])
+			return
+		case "clean":
+			clean(os.Args[2:])
+			return
+		case "clobber":
+			clobber(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
 
 	if flag.NArg() == 0 {
@@ -46,16 +60,7 @@ func main() {
 	}
 
 	r := &monk.Resolution{}
-	context := monk.NewContext(monk.DiskFS{})
-  context.Config.AssetRoot = assetRootFlag
-
-	if len(searchPathsFlag) == 0 {
-		panic("You must specify at least one path using -s")
-	}
-
-	for _, sPath := range searchPathsFlag {
-		context.SearchPath(sPath)
-	}
+	context := newContext()
 
 	if flag.NArg() == 0 {
 		panic("You must specify the asset to build.")
@@ -68,12 +73,82 @@ func main() {
 		panic(err)
 	}
 
-	built := monk.Build(r, context)
-	fmt.Println(built)
+	if err := monk.BuildTo(os.Stdout, r, context); err != nil {
+		panic(err)
+	}
+}
+
+func newContext() *monk.Context {
+	context, err := options.NewContext(monk.DiskFS{})
+	if err != nil {
+		panic(err)
+	}
+	return context
+}
+
+// Builds each of the assets named in args into the output directory, and records
+// them in the manifest.
+func precompile(args []string) {
+	fs := flag.NewFlagSet("precompile", flag.ExitOnError)
+	options.AddFlags(fs)
+	addManifestFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		panic("You must specify the assets to precompile.")
+	}
+
+	context := newContext()
+	manifest := loadManifest()
+
+	if err := manifest.Compile(context, fs.Args()...); err != nil {
+		panic(err)
+	}
+
+	if err := manifest.Save(); err != nil {
+		panic(err)
+	}
+
+	logicalPaths := []string{}
+	for logicalPath := range manifest.Assets {
+		logicalPaths = append(logicalPaths, logicalPath)
+	}
+	sort.Strings(logicalPaths)
+
+	for _, logicalPath := range logicalPaths {
+		fmt.Printf("%s -> %s\n", logicalPath, manifest.Assets[logicalPath])
+	}
+}
+
+// Removes outdated precompiled assets from the output directory.
+func clean(args []string) {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	addManifestFlags(fs)
+	fs.IntVar(&keepFlag, "keep", 2, "number of previous versions of each asset to keep")
+	fs.DurationVar(&ageFlag, "age", time.Hour, "keep any version compiled more recently than this")
+	fs.Parse(args)
+
+	if err := loadManifest().Clean(keepFlag, ageFlag); err != nil {
+		panic(err)
+	}
+}
+
+// Removes the output directory entirely.
+func clobber(args []string) {
+	fs := flag.NewFlagSet("clobber", flag.ExitOnError)
+	addManifestFlags(fs)
+	fs.Parse(args)
+
+	if err := loadManifest().Clobber(); err != nil {
+		panic(err)
+	}
 }
 
 func printUsage() {
 	fmt.Println("monk, a tool to build assets")
-	fmt.Println("  usage: monk [OPTIONS] asset_to_build.ext\n")
+	fmt.Print("  usage: monk [OPTIONS] asset_to_build.ext\n")
+	fmt.Print("         monk precompile [OPTIONS] asset_or_glob...\n")
+	fmt.Print("         monk clean [-o DIR] [-keep N] [-age DURATION]\n")
+	fmt.Print("         monk clobber [-o DIR]\n\n")
 	flag.PrintDefaults()
 }
@@ -1,15 +1,45 @@
 package monk
 
+import (
+	"crypto"
+	"fmt"
+)
+
 // Config holds various configuration options used throughout a Context and its
 // collaborators.
 type Config struct {
 	Fingerprint bool
 	AssetRoot   string
+
+	// Generate source maps for filtered assets, and append a sourceMappingURL
+	// comment to built bundles.
+	SourceMaps bool
+
+	// The hash function used to fingerprint assets. MD5, SHA-256, SHA-384 and
+	// SHA-512 are available.
+	Digest crypto.Hash
 }
 
 func NewConfig() *Config {
 	return &Config{
 		Fingerprint: false,
 		AssetRoot:   "/assets/",
+		Digest:      crypto.MD5,
+	}
+}
+
+// The hash functions that may be used for Config.Digest, keyed by name.
+var digestAlgorithms = map[string]crypto.Hash{
+	"md5":    crypto.MD5,
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// Returns the hash function named name, such as sha256, for use as Config.Digest.
+func ParseDigest(name string) (crypto.Hash, error) {
+	if algorithm, ok := digestAlgorithms[name]; ok {
+		return algorithm, nil
 	}
+	return 0, fmt.Errorf("unknown digest algorithm %q", name)
 }
@@ -1,29 +1,102 @@
 package monk
 
 import (
+	"errors"
 	"fmt"
+	"mime"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 )
 
+// A Context finds and processes assets. It is safe for concurrent use, provided its
+// search paths and Config are not changed while it is in use.
 type Context struct {
 	fs          fileSystem
 	Store       map[string]*Asset
 	SearchPaths []string
 	Config      *Config
+
+	// State shared with the views of the Context used to create assets.
+	shared *sharedState
+
+	// When the Context is a view used to create an asset, the lookup creating it.
+	call *lookupCall
+}
+
+// Guards a Context's Store and tracks the lookups in progress.
+type sharedState struct {
+	mu       sync.Mutex
+	inflight map[string]*lookupCall
+
+	// Incremented by Refresh. Assets last checked in an earlier generation are
+	// checked for changes when they are looked up.
+	generation int
+}
+
+// A lookup of a logical path. Callers that ask for the same logical path while it
+// is in progress wait for it to finish and share its result.
+type lookupCall struct {
+	done  chan struct{}
+	asset *Asset
+	err   error
+
+	// The lookup that this one is waiting for while creating its asset, used to
+	// detect assets that refer to themselves through a filter such as the url
+	// template helper.
+	waitingFor *lookupCall
+}
+
+// Returns true if call is waiting for target, directly or indirectly, or is target.
+func (call *lookupCall) waitsFor(target *lookupCall) bool {
+	for ; call != nil; call = call.waitingFor {
+		if call == target {
+			return true
+		}
+	}
+	return false
 }
 
 type Asset struct {
 	os.FileInfo
+	Path         string
 	Content      string
 	Dependencies []string
+
+	// Logical paths of assets that, along with all of their dependencies, must be
+	// excluded from any bundle that includes this asset.
+	Stubs []string
+
+	// Logical paths of assets whose changes affect this asset, even though their
+	// contents are not included in it.
+	DependOn []string
+
+	// Logical paths of assets that must be precompiled alongside this asset, such as
+	// images referenced with the url template helper.
+	Links []string
+
+	// The position in Dependencies at which the asset's own content is included,
+	// as set by require_self. Defaults to after all of its dependencies.
+	selfIndex int
+
+	// The line of the directive that declared each entry in Dependencies.
+	dependencyLines []int
+
+	// The original content of the file, and how each line of Content maps back to it.
+	source   string
+	mappings mappings
+
+	// What the asset was created from, used to check whether it is still fresh.
+	stamp assetStamp
 }
 
 func NewContext(fs fileSystem) *Context {
-	return &Context{fs, make(map[string]*Asset), []string{}, NewConfig()}
+	shared := &sharedState{inflight: make(map[string]*lookupCall)}
+	return &Context{fs, make(map[string]*Asset), []string{}, NewConfig(), shared, nil}
 }
 
 // Append a path to the list of asset paths to be searched for assets.
@@ -32,52 +105,106 @@ func (c *Context) SearchPath(dirpath string) (*Context, error) {
 	return c, nil
 }
 
-// Return the contents of a file based on its logical path. Loads the content from
-// disk if needed.
+// Return the asset at logicalPath, creating it if it is not in the Store or, once
+// the Context has been refreshed, if it has changed. Callers that look up the same
+// logical path at the same time share a single lookup, so each asset is only
+// processed once.
 //
 // logicalPath must have at least one extension.
 func (c *Context) lookup(logicalPath string) (*Asset, error) {
+	s := c.shared
+	s.mu.Lock()
 	asset, ok := c.Store[logicalPath]
-	if ok {
+	if ok && asset.stamp.checked == s.generation {
+		s.mu.Unlock()
 		return asset, nil
 	}
 
-	asset, err := c.findAssetInSearchPaths(logicalPath)
-	// this should return just the asset path, which can then be passed to createAsset
+	call, ok := s.inflight[logicalPath]
+	if ok && c.call != nil && call.waitsFor(c.call) {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%q refers to itself while being processed", logicalPath)
+	}
+	if !ok {
+		call = &lookupCall{done: make(chan struct{})}
+		s.inflight[logicalPath] = call
+	}
+	if c.call != nil {
+		c.call.waitingFor = call
+		defer func() {
+			s.mu.Lock()
+			c.call.waitingFor = nil
+			s.mu.Unlock()
+		}()
+	}
+	generation := s.generation
+	s.mu.Unlock()
 
-	if err != nil {
-		/*fmt.Printf("lookup %q failed: %q\n", logicalPath, err.Error())*/
-		return nil, err
+	if ok {
+		<-call.done
+		return call.asset, call.err
 	}
 
-	c.Store[logicalPath] = asset
-	return asset, nil
+	view := *c
+	view.call = call
+	call.asset, call.err = view.load(logicalPath, asset)
+
+	s.mu.Lock()
+	delete(s.inflight, logicalPath)
+	if call.err == nil {
+		call.asset.stamp.checked = generation
+		c.Store[logicalPath] = call.asset
+	} else {
+		delete(c.Store, logicalPath)
+	}
+	s.mu.Unlock()
+	close(call.done)
+
+	return call.asset, call.err
+}
+
+// Returns asset, which was created for logicalPath earlier, if it is still fresh.
+// Otherwise creates the asset again.
+func (c *Context) load(logicalPath string, asset *Asset) (*Asset, error) {
+	if asset != nil {
+		if fresh, ok := c.isFresh(logicalPath, asset); ok {
+			return fresh, nil
+		}
+	}
+
+	return c.findAssetInSearchPaths(logicalPath)
 }
 
 // TODO this should return a Match object that includes absPath and logicalPath
 func (c *Context) findPathInSearchPaths(logicalPath string) (string, os.FileInfo, error) {
 	if len(c.SearchPaths) == 0 {
-		return "", nil, fmt.Errorf("No search paths have been defined.")
+		return "", nil, ErrNoSearchPaths
 	}
 
 	// logicalPath must have at least one extension.
 	if path.Ext(logicalPath) == "" {
-		return "", nil, fmt.Errorf("Can not find '%s'. An extension is required to find an asset.", logicalPath)
+		return "", nil, &MissingExtensionError{logicalPath}
 	}
 
+	tried := []string{}
 	for _, searchPath := range c.SearchPaths {
 		absPath := path.Join(searchPath, logicalPath)
+		tried = append(tried, absPath)
 
 		// Look for exact match
 		info, err := c.fs.Stat(absPath)
 		if os.IsNotExist(err) {
 
-			// Search the entire directory for a matching base name
-			absPath, err := c.searchDirectory(searchPath, logicalPath)
+			// Search the containing directory for a matching base name
+			absPath, err := c.searchDirectory(path.Dir(absPath), path.Base(logicalPath))
 			if err != nil {
 				continue
 			}
 
+			info, err = c.fs.Stat(absPath)
+			if err != nil {
+				return "", nil, err
+			}
 			return absPath, info, nil
 		}
 
@@ -87,7 +214,7 @@ func (c *Context) findPathInSearchPaths(logicalPath string) (string, os.FileInfo
 		return absPath, info, nil
 	}
 
-	return "", nil, fmt.Errorf("Could not find a file matching %q in %v", logicalPath, c.SearchPaths)
+	return "", nil, &NotFoundError{logicalPath, c.SearchPaths, tried}
 }
 
 func (c *Context) findAssetInSearchPaths(logicalPath string) (*Asset, error) {
@@ -103,53 +230,299 @@ func (c *Context) findAssetInSearchPaths(logicalPath string) (*Asset, error) {
 //
 // TODO passing both FileInfo and an absolute path here seems redundant.
 func (c *Context) createAsset(absPath string, info os.FileInfo) (*Asset, error) {
-	rawContent, err := c.loadAssetContent(absPath)
+	rawContent, err := c.fs.ReadFile(absPath)
 	if err != nil {
 		/*fmt.Printf("failed to load asset content for %q\n", absPath)*/
 		return nil, err
 	}
-	content, dependencies := extractDependencies(rawContent)
 
-	for i, dep := range dependencies {
-		if path.Ext(dep) == "" {
-			ext := strings.Split(path.Base(absPath), ".")[1]
-			dependencies[i] = fmt.Sprintf("%s.%s", dep, ext)
+	// Directives are written in the syntax of the source file, so they must be
+	// extracted before any filters are applied.
+	h, err := parseHeader(string(rawContent), directiveSyntax(absPath))
+	if err != nil {
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) {
+			syntaxErr.Path = absPath
+		}
+		return nil, err
+	}
+
+	asset := &Asset{
+		FileInfo:     info,
+		Path:         absPath,
+		source:       string(rawContent),
+		mappings:     lineMappings(h.sourceLines()),
+		Dependencies: []string{},
+		Stubs:        []string{},
+		DependOn:     []string{},
+		Links:        []string{},
+		selfIndex:    -1,
+	}
+
+	_, ext, _ := splitExtensions(absPath)
+
+	for _, d := range h.directives {
+		switch d.name {
+		case "require_self":
+			if asset.selfIndex >= 0 {
+				return nil, fmt.Errorf("require_self may only be used once in %q", absPath)
+			}
+			asset.selfIndex = len(asset.Dependencies)
+		case "require", "stub", "depend_on", "link":
+			logicalPath := d.arg
+			if isRelative(logicalPath) {
+				searchPath, fullPath, err := c.resolveDirectivePath(absPath, d.arg)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %s", absPath, d.line, err)
+				}
+				if fullPath == searchPath {
+					return nil, fmt.Errorf("%s:%d: Can not %s %q: it is a search path", absPath, d.line, d.name, d.arg)
+				}
+				logicalPath = strings.TrimPrefix(fullPath, searchPath+"/")
+			}
+			logicalPath = c.withExtension(logicalPath, ext)
+
+			switch d.name {
+			case "require":
+				asset.require(logicalPath, d.line)
+			case "stub":
+				asset.Stubs = append(asset.Stubs, logicalPath)
+			case "depend_on":
+				asset.dependOn(logicalPath)
+			case "link":
+				asset.link(logicalPath)
+			}
+		case "require_tree", "require_directory":
+			searchPath, root, err := c.resolveDirectivePath(absPath, d.arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", absPath, d.line, err)
+			}
+			recursive := d.name == "require_tree"
+			deps, err := c.explodeDirectory(absPath, searchPath, root, ext, recursive)
+			if err != nil {
+				return nil, err
+			}
+			asset.stamp.trees = append(asset.stamp.trees, treeStamp{searchPath, root, ext, recursive, deps})
+			for _, dep := range deps {
+				asset.require(dep, d.line)
+			}
 		}
 	}
 
-	return &Asset{info, content, dependencies}, nil
+	if asset.selfIndex < 0 {
+		asset.selfIndex = len(asset.Dependencies)
+	}
+
+	asset.Content, err = c.applyFilters(asset, absPath, h.content())
+	if err != nil {
+		return nil, err
+	}
+	c.stampDependencies(asset)
+
+	return asset, nil
+}
+
+// Adds logicalPath to the asset's dependencies, as declared on line.
+func (a *Asset) require(logicalPath string, line int) {
+	a.Dependencies = append(a.Dependencies, logicalPath)
+	a.dependencyLines = append(a.dependencyLines, line)
+}
+
+// Records that asset must be considered stale whenever logicalPath changes.
+func (a *Asset) dependOn(logicalPath string) {
+	if !contains(logicalPath, a.DependOn) {
+		a.DependOn = append(a.DependOn, logicalPath)
+	}
+}
+
+// Records that logicalPath must be precompiled along with asset. Linked assets are
+// also treated as freshness dependencies.
+func (a *Asset) link(logicalPath string) {
+	if !contains(logicalPath, a.Links) {
+		a.Links = append(a.Links, logicalPath)
+	}
+	a.dependOn(logicalPath)
 }
 
-// Converts the wildcard/directory dependencies such as foo/* into an
-// explicit list of dependencies based on what files are currently
-// located at the provided path.
-func explodeDependencies(absPath string, dependencies []string, fs fileSystem) []string {
+// Adds ext to logicalPath if it does not already have an extension. Since names
+// such as lodash.min-4 contain dots, only suffixes that are ext itself, the
+// extension of a filter or a known file type, or part of the name of an existing
+// file are treated as extensions.
+func (c *Context) withExtension(logicalPath string, ext string) string {
+	suffix := strings.TrimPrefix(path.Ext(logicalPath), ".")
+	if suffix == "" {
+		return fmt.Sprintf("%s.%s", logicalPath, ext)
+	}
+
+	_, isFilter := filters[suffix]
+	_, hasComments := commentSyntaxes[suffix]
+	if suffix == ext || isFilter || hasComments || mime.TypeByExtension("."+suffix) != "" {
+		return logicalPath
+	}
+	for _, searchPath := range c.SearchPaths {
+		if _, err := c.fs.Stat(path.Join(searchPath, logicalPath)); err == nil {
+			return logicalPath
+		}
+	}
+	return fmt.Sprintf("%s.%s", logicalPath, ext)
+}
+
+// Returns true if logicalPath should be resolved relative to the requiring asset's
+// own directory rather than the search paths.
+func isRelative(logicalPath string) bool {
+	return logicalPath == "." || logicalPath == ".." ||
+		strings.HasPrefix(logicalPath, "./") || strings.HasPrefix(logicalPath, "../")
+}
+
+// Resolves the argument of a directive found in the asset at absPath to a full path,
+// returning it along with the search path that contains it. Relative arguments such
+// as ./foo or ../foo are resolved against the asset's directory, while all others
+// are resolved against the search path that contains the asset.
+//
+// It is an error for the result to fall outside of every search path.
+func (c *Context) resolveDirectivePath(absPath string, arg string) (string, string, error) {
+	var fullPath string
+	if isRelative(arg) {
+		fullPath = path.Join(path.Dir(absPath), arg)
+	} else {
+		searchPath, ok := c.searchPathFor(absPath)
+		if !ok {
+			return "", "", fmt.Errorf("%q is not located in any search path", absPath)
+		}
+		fullPath = path.Join(searchPath, arg)
+	}
+
+	searchPath, ok := c.searchPathFor(fullPath)
+	if !ok {
+		return "", "", fmt.Errorf("%q resolves to %q, which is outside of the search paths %v", arg, fullPath, c.SearchPaths)
+	}
+	return searchPath, fullPath, nil
+}
+
+// Converts a require_tree or require_directory directive into an explicit list of
+// logical paths based on what files are currently located in the directory root,
+// which is inside searchPath.
+//
+// Only files with the same type as the requiring asset at absPath are included, and
+// the requiring asset itself is skipped. Entries are returned in lexical order, with
+// the contents of subdirectories included in place when recursive is true.
+func (c *Context) explodeDirectory(absPath string, searchPath string, root string, ext string, recursive bool) ([]string, error) {
+	if info, err := c.fs.Stat(root); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("Can not require %q from %q: not a directory", root, absPath)
+	}
+
+	files, err := c.listFiles(root, recursive)
+	if err != nil {
+		return nil, err
+	}
+
 	result := []string{}
-	for _, req := range dependencies {
-		if strings.HasSuffix(req, "/*") {
-			dirName := req[0 : len(req)-2]
+	for _, fullPath := range files {
+		if fullPath == absPath {
+			continue
+		}
 
-			infos, err := fs.ReadDir(dirName)
+		logicalPath := logicalPathFor(strings.TrimPrefix(fullPath, searchPath+"/"))
+		if path.Ext(logicalPath) != "."+ext {
+			continue
+		}
+		result = append(result, logicalPath)
+	}
+	return result, nil
+}
+
+// Returns the path of each file in dir in lexical order, with the contents of
+// subdirectories included in place when recursive is true.
+func (c *Context) listFiles(dir string, recursive bool) ([]string, error) {
+	infos, err := c.fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byName(infos))
 
-			// TODO return err instead of panicing.
+	result := []string{}
+	for _, info := range infos {
+		fullPath := path.Join(dir, info.Name())
+		if !info.IsDir() {
+			result = append(result, fullPath)
+		} else if recursive {
+			nested, err := c.listFiles(fullPath, recursive)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
+			result = append(result, nested...)
+		}
+	}
+	return result, nil
+}
 
-			// get contents of directory, and add them (minus extensions) to result
-			for _, info := range infos {
-				if !info.IsDir() { // recursive requires not currently supported
-					fullPath := path.Join(dirName, info.Name())
-					result = append(result, fullPath)
-				}
+// Returns the logical path of every asset in the search paths. Assets that are
+// shadowed by an asset with the same logical path in an earlier search path are
+// only included once.
+func (c *Context) LogicalPaths() ([]string, error) {
+	result := []string{}
+	for _, searchPath := range c.SearchPaths {
+		files, err := c.listFiles(searchPath, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, fullPath := range files {
+			logicalPath := logicalPathFor(strings.TrimPrefix(fullPath, searchPath+"/"))
+			if !contains(logicalPath, result) {
+				result = append(result, logicalPath)
 			}
-		} else {
-			result = append(result, req)
 		}
 	}
-	return result
+	return result, nil
 }
 
+// Return the search path that contains or is equal to absPath.
+func (c *Context) searchPathFor(absPath string) (string, bool) {
+	for _, searchPath := range c.SearchPaths {
+		if absPath == searchPath || strings.HasPrefix(absPath, searchPath+"/") {
+			return searchPath, true
+		}
+	}
+	return "", false
+}
+
+// Strips any filter extensions from a file path, leaving only the extension that
+// determines the asset's final type. foo/bar.js.coffee becomes foo/bar.js.
+func logicalPathFor(filePath string) string {
+	name, ext, _ := splitExtensions(filePath)
+	if ext == "" {
+		return filePath
+	}
+	return path.Join(path.Dir(filePath), name+"."+ext)
+}
+
+// Splits the base name of filePath into the asset's name, the extension that
+// determines its final type, and any filter extensions that follow it. Only
+// extensions with a registered filter are treated as filter extensions, so
+// jquery.min.js.coffee has the name jquery.min, the type js and the filter coffee.
+func splitExtensions(filePath string) (string, string, []string) {
+	parts := strings.Split(path.Base(filePath), ".")
+	if len(parts) < 2 {
+		return parts[0], "", []string{}
+	}
+
+	i := len(parts) - 1
+	for i > 1 {
+		if _, ok := filters[parts[i]]; !ok {
+			break
+		}
+		i--
+	}
+
+	return strings.Join(parts[:i], "."), parts[i], parts[i+1:]
+}
+
+type byName []os.FileInfo
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].Name() < s[j].Name() }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 // Iterates over the immediate child nodes of dirPath, returning the absolute path
 // to a matching file if one is found.
 func (c *Context) searchDirectory(dirPath string, logicalPath string) (string, error) {
@@ -172,24 +545,22 @@ func (c *Context) searchDirectory(dirPath string, logicalPath string) (string, e
 }
 
 // Loads a file from filePath, filtering its contents through a series filters based
-// on the additional extensions in the filename. The first extension is assumed to
-// be the final type of the file.
+// on the additional extensions in the filename. See splitExtensions for how the
+// final type of the file is determined.
 func (c *Context) loadAssetContent(filePath string) (string, error) {
 	bytes, err := c.fs.ReadFile(filePath)
 	if err != nil {
 		return "", err
 	}
 
-	content := string(bytes)
-
-	exts := strings.Split(path.Base(filePath), ".")
-
-	// Nothing else to do if there aren't additional extensions
-	if len(exts) < 3 {
-		return content, nil
-	}
+	return c.applyFilters(nil, filePath, string(bytes))
+}
 
-	exts = exts[2:]
+// Runs content through the filters named by the additional extensions in filePath,
+// starting with the last one. asset is the asset being created, if any, and its
+// source mappings are updated to reflect the filtered content.
+func (c *Context) applyFilters(asset *Asset, filePath string, content string) (string, error) {
+	_, _, exts := splitExtensions(filePath)
 
 	// Reverse the order of remaining extensions
 	for i, j := 0, len(exts)-1; i < j; i, j = i+1, j-1 {
@@ -197,11 +568,23 @@ func (c *Context) loadAssetContent(filePath string) (string, error) {
 	}
 
 	for _, ext := range exts {
-		filtered, err := ApplyFilter(c, content, ext)
+		filtered, sourceMap, err := applyFilter(c, asset, content, ext)
 		if err != nil {
+			var filterErr *FilterError
+			if errors.As(err, &filterErr) && filterErr.Path == "" {
+				filterErr.Path = filePath
+			}
 			return "", err
 		}
 		content = filtered
+
+		if asset != nil && sourceMap != nil {
+			m, err := decodeMappings(sourceMap.Mappings)
+			if err != nil {
+				return "", &FilterError{Extension: ext, Path: filePath, Err: err}
+			}
+			asset.mappings = m.compose(asset.mappings)
+		}
 	}
 
 	return content, nil
@@ -2,6 +2,8 @@ package monk
 
 import (
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -88,17 +90,211 @@ func TestSearchDirectory(t *testing.T) {
 	}
 }
 
-func TestExplodeDepencies(t *testing.T) {
+func TestRequireTree(t *testing.T) {
 	fs := NewTestFS()
-	fs.File("bar/1", "")
-	fs.File("bar/2", "")
-	fs.File("baz", "")
-
-	d := []string{"foo", "bar/*", "baz"}
-	dirPath := "assets"
-	exploded := explodeDependencies(dirPath, d, fs)
-	expected := []string{"foo", "bar/1", "bar/2", "baz"}
-	if !eq(expected, exploded) {
-		t.Errorf("explodeDependencies(%v) = %v, want %v", d, exploded, expected)
+	fs.File("assets/app.js", "//= require_tree vendor\n")
+	fs.File("assets/vendor/b.js", "")
+	fs.File("assets/vendor/a.js.coffee", "")
+	fs.File("assets/vendor/lib/c.js", "")
+	fs.File("assets/vendor/lib/d.css", "")
+	fs.File("assets/vendor/z.js", "")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+
+	asset, err := c.lookup("app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"vendor/a.js", "vendor/b.js", "vendor/lib/c.js", "vendor/z.js"}
+	if !eq(asset.Dependencies, expected) {
+		t.Errorf("require_tree dependencies = %v, want %v", asset.Dependencies, expected)
+	}
+
+	r := &Resolution{}
+	if err := r.Resolve("app.js", c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequireDirectory(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js", "//= require_directory .\n")
+	fs.File("assets/b.js", "")
+	fs.File("assets/a.js", "")
+	fs.File("assets/lib/c.js", "")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+
+	asset, err := c.lookup("app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a.js", "b.js"}
+	if !eq(asset.Dependencies, expected) {
+		t.Errorf("require_directory dependencies = %v, want %v", asset.Dependencies, expected)
+	}
+
+	fs.File("assets/broken.js", "//= require_directory missing\n")
+	if _, err := c.lookup("broken.js"); err == nil {
+		t.Error("require_directory of a missing directory should fail")
+	}
+}
+
+func TestDependOnAndLink(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js.tmpl", "//= require a\n//= depend_on data.json\n//= link logo.png\n"+`var icon = '{{url "icon.png"}}';`)
+	fs.File("assets/a.js", "")
+	fs.File("assets/data.json", "{}")
+	fs.File("assets/logo.png", "")
+	fs.File("assets/icon.png", "")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+
+	asset, err := c.lookup("app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"a.js"}; !eq(asset.Dependencies, expected) {
+		t.Errorf("Expected dependencies to be %q, got %q", expected, asset.Dependencies)
+	}
+	if expected := []string{"data.json", "logo.png", "icon.png"}; !eq(asset.DependOn, expected) {
+		t.Errorf("Expected depend_on to be %q, got %q", expected, asset.DependOn)
+	}
+	if expected := []string{"logo.png", "icon.png"}; !eq(asset.Links, expected) {
+		t.Errorf("Expected links to be %q, got %q", expected, asset.Links)
+	}
+
+	r := &Resolution{}
+	if err := r.Resolve("app.js", c); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a.js", "app.js"}; !eq(r.Resolved, expected) {
+		t.Errorf("depend_on and link should not be concatenated, got %q", r.Resolved)
+	}
+}
+
+func TestRelativeRequires(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("app/assets/widgets/index.js", "//= require ./slider\n//= require ../shared/util\n//= require_tree ./lib\n//= require ../../../vendor/assets/jquery\n")
+	fs.File("app/assets/widgets/slider.js", "")
+	fs.File("app/assets/widgets/lib/a.js", "")
+	fs.File("app/assets/shared/util.js", "")
+	fs.File("vendor/assets/jquery.js", "")
+	fs.File("app/assets/escape.js", "//= require ../secret\n")
+	fs.File("app/secret.js", "")
+
+	c := NewContext(fs)
+	c.SearchPath("app/assets")
+	c.SearchPath("vendor/assets")
+
+	asset, err := c.lookup("widgets/index.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"widgets/slider.js", "shared/util.js", "widgets/lib/a.js", "jquery.js"}
+	if !eq(asset.Dependencies, expected) {
+		t.Errorf("Expected dependencies to be %q, got %q", expected, asset.Dependencies)
+	}
+
+	r := &Resolution{}
+	if err := r.Resolve("widgets/index.js", c); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.lookup("escape.js"); err == nil || !strings.Contains(err.Error(), "outside of the search paths") {
+		t.Errorf("requires outside of the search paths should fail, got: %v", err)
+	}
+}
+
+func TestRealWorldNames(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js", "//= require jquery-ui\n//= require vendor/backbone\n//= require lodash.min-4\n//= depend_on config.json\n")
+	fs.File("assets/jquery-ui.js.coffee", "")
+	fs.File("assets/vendor/backbone.js", "")
+	fs.File("assets/lodash.min-4.js", "")
+	fs.File("assets/config.json", "{}")
+	fs.File("assets/broken.js", "// header\n//= require one two\n")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+
+	r := &Resolution{}
+	if err := r.Resolve("app.js", c); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"jquery-ui.js", "vendor/backbone.js", "lodash.min-4.js", "app.js"}
+	if !eq(r.Resolved, expected) {
+		t.Errorf("Resolved = %q, want %q", r.Resolved, expected)
+	}
+
+	if app, _ := c.lookup("app.js"); !eq(app.DependOn, []string{"config.json"}) {
+		t.Errorf("expected known extensions to be kept, got: %q", app.DependOn)
+	}
+
+	_, err := c.lookup("broken.js")
+	if err == nil || err.Error() != `assets/broken.js:2: unexpected "two" after path` {
+		t.Errorf("malformed directives should report the file and line, got: %v", err)
+	}
+}
+
+func TestSelfReferencingAsset(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/a.css.tmpl", "a { background: url('{{url \"a.css\"}}'); }\n")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+	c.Config.Fingerprint = true
+
+	_, err := c.lookup("a.css")
+	if err == nil || !strings.Contains(err.Error(), `"a.css" refers to itself`) {
+		t.Errorf("expected an asset that fingerprints itself to fail, got: %v", err)
+	}
+}
+
+func TestConcurrentLookups(t *testing.T) {
+	useFilter(t, "counted", counter)
+
+	fs := NewTestFS()
+	fs.File("assets/app.js.counted", "//= require lib\napp();\n")
+	fs.File("assets/lib.js.counted", "lib();\n")
+	fs.File("assets/a.css.tmpl", "a { background: url('{{url \"b.css\"}}'); }\n")
+	fs.File("assets/b.css.tmpl", "b { background: url('{{url \"a.css\"}}'); }\n")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+	c.Config.Fingerprint = true
+
+	count := atomic.LoadInt32(&counter.count)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%5 == 0 {
+				c.Refresh()
+			}
+			r := &Resolution{}
+			if err := r.Resolve("app.js", c); err != nil {
+				t.Error(err)
+			}
+
+			// Assets that refer to each other fail instead of waiting for each other.
+			if _, err := c.lookup([]string{"a.css", "b.css"}[i%2]); err == nil || !strings.Contains(err.Error(), "refers to itself") {
+				t.Errorf("expected assets that refer to each other to fail, got: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if processed := atomic.LoadInt32(&counter.count) - count; processed != 2 {
+		t.Errorf("expected each asset to be processed once, got: %d", processed)
 	}
 }
@@ -0,0 +1,89 @@
+package monk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoSearchPaths is returned when looking up an asset in a Context that has no
+// search paths.
+var ErrNoSearchPaths = errors.New("No search paths have been defined.")
+
+// A NotFoundError is returned when no file matching LogicalPath exists in any of
+// the SearchPaths. Paths holds each absolute path that was tried.
+type NotFoundError struct {
+	LogicalPath string
+	SearchPaths []string
+	Paths       []string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("Could not find a file matching %q in %v", e.LogicalPath, e.SearchPaths)
+}
+
+// A MissingExtensionError is returned when looking up a logical path that has no
+// extension, since the extension is needed to determine the asset's type.
+type MissingExtensionError struct {
+	LogicalPath string
+}
+
+func (e *MissingExtensionError) Error() string {
+	return fmt.Sprintf("Can not find '%s'. An extension is required to find an asset.", e.LogicalPath)
+}
+
+// A FilterError is returned when the filter for Extension fails to process the file
+// at Path. Stderr holds anything the filter wrote to standard error, such as the
+// compiler errors reported by coffee or lessc.
+type FilterError struct {
+	Extension string
+	Path      string
+	Stderr    string
+	Err       error
+}
+
+func (e *FilterError) Error() string {
+	msg := fmt.Sprintf("%s filter failed", e.Extension)
+	if e.Path != "" {
+		msg += fmt.Sprintf(" for %q", e.Path)
+	}
+	msg += ": " + e.Err.Error()
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg += "\n" + stderr
+	}
+	return msg
+}
+
+func (e *FilterError) Unwrap() error {
+	return e.Err
+}
+
+// A SyntaxError describes a malformed directive in an asset's header.
+type SyntaxError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+}
+
+// A CycleError is returned when an asset ends up requiring itself. Chain holds each
+// require in the cycle, starting and ending with the same asset.
+type CycleError struct {
+	Chain []Require
+}
+
+func (e *CycleError) Error() string {
+	names := []string{e.Chain[0].From}
+	for _, req := range e.Chain {
+		names = append(names, req.To)
+	}
+
+	msg := fmt.Sprintf("circular dependency detected: %s", strings.Join(names, " -> "))
+	for _, req := range e.Chain {
+		msg += fmt.Sprintf("\n  %s:%d: %s requires %s", req.Path, req.Line, req.From, req.To)
+	}
+	return msg
+}
@@ -0,0 +1,89 @@
+package monk
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNotFoundError(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/a.js", "//= require b\n")
+	fs.File("assets/b.js", "//= require missing\n")
+
+	c := NewContext(fs)
+
+	r := &Resolution{}
+	if err := r.Resolve("a.js", c); !errors.Is(err, ErrNoSearchPaths) {
+		t.Errorf("expected ErrNoSearchPaths, got: %v", err)
+	}
+
+	c.SearchPath("assets")
+	c.SearchPath("vendor")
+
+	r = &Resolution{}
+	err := r.Resolve("a.js", c)
+
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected a *NotFoundError, got: %v", err)
+	}
+	if notFound.LogicalPath != "missing.js" {
+		t.Errorf("expected LogicalPath to be %q, got: %q", "missing.js", notFound.LogicalPath)
+	}
+	if expected := []string{"assets", "vendor"}; !eq(notFound.SearchPaths, expected) {
+		t.Errorf("expected SearchPaths to be %q, got: %q", expected, notFound.SearchPaths)
+	}
+	if expected := []string{"assets/missing.js", "vendor/missing.js"}; !eq(notFound.Paths, expected) {
+		t.Errorf("expected Paths to be %q, got: %q", expected, notFound.Paths)
+	}
+}
+
+func TestMissingExtensionError(t *testing.T) {
+	c := NewContext(NewTestFS())
+	c.SearchPath("assets")
+
+	_, err := c.lookup("simple")
+
+	var missing *MissingExtensionError
+	if !errors.As(err, &missing) || missing.LogicalPath != "simple" {
+		t.Errorf("expected a *MissingExtensionError for %q, got: %v", "simple", err)
+	}
+}
+
+func TestFilterError(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/a.js.tmpl", `{{url "missing.png"}}`)
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+
+	_, err := c.lookup("a.js")
+
+	var filterErr *FilterError
+	if !errors.As(err, &filterErr) {
+		t.Fatalf("expected a *FilterError, got: %v", err)
+	}
+	if filterErr.Extension != "tmpl" || filterErr.Path != "assets/a.js.tmpl" {
+		t.Errorf("expected the tmpl filter to fail for assets/a.js.tmpl, got: %v", filterErr)
+	}
+
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) || notFound.LogicalPath != "missing.png" {
+		t.Errorf("expected the filter error to wrap a *NotFoundError, got: %v", err)
+	}
+
+	_, err = ApplyFilter(c, "", "unknown")
+	if !errors.As(err, &filterErr) || filterErr.Extension != "unknown" {
+		t.Errorf("expected a *FilterError for a missing filter, got: %v", err)
+	}
+}
+
+func TestFilterErrorStderr(t *testing.T) {
+	filter := AssetFilter{}
+	_, err := filter.RunCommand("sh", "", "sh", "-c", "echo oops >&2; exit 1")
+
+	var filterErr *FilterError
+	if !errors.As(err, &filterErr) || filterErr.Stderr != "oops\n" {
+		t.Errorf("expected a *FilterError with stderr, got: %v", err)
+	}
+}
@@ -1,24 +1,265 @@
 package monk
 
 import (
-	"regexp"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
 )
 
-// Parse an asset's dependencies and return the content stripped of these declarations,
-// along with a slice containing the dependencies that were declared.
-func extractDependencies(fileContents string) (string, []string) {
-	pattern := `(?m)^\s*//=\s*require\s+['"]?([\w\.]+)["']?\s*$?`
-	r, err := regexp.Compile(pattern)
+// A directive is a single `//= name argument` declaration found in an asset's header.
+type directive struct {
+	name string
+	arg  string
+	line int
+}
+
+// The comment syntax of a file type. Directives may appear in line comments, in a
+// single line block comment such as `/*= require foo */` or on any line inside a
+// larger block comment, such as ` *= require foo`.
+type commentSyntax struct {
+	line       []string
+	blockStart string
+	blockEnd   string
+}
+
+var cStyleComments = commentSyntax{[]string{"//"}, "/*", "*/"}
+
+// The comment syntax used to find directives, keyed by file extension.
+var commentSyntaxes = map[string]commentSyntax{
+	"js":     cStyleComments,
+	"css":    cStyleComments,
+	"less":   cStyleComments,
+	"coffee": {[]string{"#"}, "###", "###"},
+}
+
+// The names of all supported directives, and whether they take a path argument.
+var directiveNames = map[string]bool{
+	"require":           true,
+	"require_self":      false,
+	"require_tree":      true,
+	"require_directory": true,
+	"stub":              true,
+	"depend_on":         true,
+	"link":              true,
+}
+
+// Return the extension whose comment syntax should be used to find directives in
+// the file at filePath. Extensions are checked from last to first, so foo.js.coffee
+// uses CoffeeScript comments while foo.js.tmpl falls back to JavaScript.
+func directiveSyntax(filePath string) string {
+	exts := strings.Split(path.Base(filePath), ".")[1:]
+	for i := len(exts) - 1; i >= 0; i-- {
+		if _, ok := commentSyntaxes[exts[i]]; ok {
+			return exts[i]
+		}
+	}
+	return ""
+}
+
+// Parse the directives in an asset's header and return the content stripped of these
+// declarations, along with a slice containing the directives that were declared.
+// syntax is the extension used to look up how comments are written.
+//
+// The header is made up of the comments and blank lines at the very beginning of
+// the file; directives appearing after the first line of code are left alone. Blank
+// lines that follow a directive are removed along with it.
+//
+// A comment starting with = followed by a word is a directive, and a *SyntaxError
+// is returned if it is not well formed. The error's Path is left for the caller to
+// fill in.
+func extractDependencies(fileContents string, syntax string) (string, []directive, error) {
+	h, err := parseHeader(fileContents, syntax)
 	if err != nil {
-		panic(err)
+		return "", nil, err
+	}
+	return h.content(), h.directives, nil
+}
+
+// The result of parsing an asset's header.
+type header struct {
+	directives []directive
+
+	// The lines of the file that remain once directives are removed, each with its
+	// trailing newline, and the zero based line each of them came from.
+	kept      []string
+	keptLines []int
+}
+
+// Return the content of the file, stripped of its directives.
+func (h *header) content() string {
+	return strings.Join(h.kept, "")
+}
+
+// Return the zero based line of the original file that each line of content came
+// from.
+func (h *header) sourceLines() []int {
+	lines := make([]int, 0, len(h.keptLines))
+	for i, line := range h.kept {
+		if line != "" {
+			lines = append(lines, h.keptLines[i])
+		}
+	}
+	return lines
+}
+
+func (h *header) keep(line string, n int) {
+	h.kept = append(h.kept, line)
+	h.keptLines = append(h.keptLines, n)
+}
+
+// Parses the header of fileContents as described by extractDependencies.
+func parseHeader(fileContents string, syntax string) (*header, error) {
+	lines := strings.SplitAfter(fileContents, "\n")
+	h := &header{
+		directives: make([]directive, 0),
+		kept:       make([]string, 0, len(lines)),
+		keptLines:  make([]int, 0, len(lines)),
 	}
 
-	requires := make([]string, 0)
-	stripped := r.ReplaceAllStringFunc(fileContents, func(line string) string {
-		match := r.FindStringSubmatch(line)
-		requires = append(requires, match[1])
-		return ""
-	})
+	comments, ok := commentSyntaxes[syntax]
+	if !ok {
+		for i, line := range lines {
+			h.keep(line, i)
+		}
+		return h, nil
+	}
+
+	inBlock := false
+	afterDirective := false
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
 
-	return stripped, requires
+		if trimmed == "" {
+			if !afterDirective {
+				h.keep(line, i)
+			}
+			continue
+		}
+
+		// The text of the comment, minus any comment markers.
+		var text string
+		opensBlock, closesBlock := false, false
+
+		if inBlock {
+			text = trimmed
+		} else if strings.HasPrefix(trimmed, comments.blockStart) {
+			text = trimmed[len(comments.blockStart):]
+			opensBlock = true
+		} else if prefix, ok := lineCommentPrefix(trimmed, comments); ok {
+			text = trimmed[len(prefix):]
+		} else {
+			// The first line of code ends the header.
+			for j := i; j < len(lines); j++ {
+				h.keep(lines[j], j)
+			}
+			break
+		}
+
+		if inBlock || opensBlock {
+			if end := strings.Index(text, comments.blockEnd); end >= 0 {
+				text = text[:end]
+				closesBlock = true
+			}
+			inBlock = !closesBlock
+		}
+		if !opensBlock {
+			text = strings.TrimLeft(text, "*#")
+		}
+
+		d, ok, err := parseDirective(strings.TrimSpace(text))
+		if err != nil {
+			return nil, &SyntaxError{Line: i + 1, Msg: err.Error()}
+		}
+		if !ok {
+			h.keep(line, i)
+			afterDirective = false
+			continue
+		}
+
+		d.line = i + 1
+		h.directives = append(h.directives, d)
+		afterDirective = true
+
+		// Keep the comment balanced when a directive shares its line with the start
+		// or end of a larger block comment.
+		indent := line[:strings.Index(line, trimmed)]
+		eol := line[strings.Index(line, trimmed)+len(trimmed):]
+		if opensBlock && !closesBlock {
+			h.keep(indent+comments.blockStart+eol, i)
+		} else if closesBlock && !opensBlock {
+			h.keep(indent+comments.blockEnd+eol, i)
+		}
+	}
+
+	return h, nil
+}
+
+// Parse the text of a comment, minus its comment markers, as a directive. Returns
+// false if the text is an ordinary comment.
+//
+// The argument is a logical path that may be wrapped in single or double quotes.
+// Unquoted paths may contain any characters other than whitespace and quotes.
+func parseDirective(text string) (directive, bool, error) {
+	if !strings.HasPrefix(text, "=") {
+		return directive{}, false, nil
+	}
+
+	text = strings.TrimSpace(text[1:])
+	if text == "" || !unicode.IsLetter(rune(text[0])) {
+		// Lines such as //===== are decoration, not directives.
+		return directive{}, false, nil
+	}
+
+	name, arg := text, ""
+	if i := strings.IndexFunc(text, unicode.IsSpace); i >= 0 {
+		name, arg = text[:i], strings.TrimSpace(text[i:])
+	}
+
+	takesArg, ok := directiveNames[name]
+	if !ok {
+		return directive{}, false, fmt.Errorf("unknown directive %q", name)
+	}
+	if !takesArg {
+		if arg != "" {
+			return directive{}, false, fmt.Errorf("%s does not take an argument, got %q", name, arg)
+		}
+		return directive{name: name}, true, nil
+	}
+	if arg == "" {
+		return directive{}, false, fmt.Errorf("%s requires a path", name)
+	}
+
+	if quote := arg[0]; quote == '"' || quote == '\'' {
+		end := strings.IndexByte(arg[1:], quote)
+		if end < 0 {
+			return directive{}, false, fmt.Errorf("unterminated path %s", arg)
+		}
+		if rest := arg[end+2:]; rest != "" {
+			return directive{}, false, fmt.Errorf("unexpected %q after path", rest)
+		}
+		arg = arg[1 : end+1]
+		if arg == "" {
+			return directive{}, false, fmt.Errorf("%s requires a path", name)
+		}
+	} else if i := strings.IndexFunc(arg, unicode.IsSpace); i >= 0 {
+		return directive{}, false, fmt.Errorf("unexpected %q after path", strings.TrimSpace(arg[i:]))
+	}
+
+	if strings.ContainsAny(arg, `"'`) {
+		return directive{}, false, fmt.Errorf("invalid path %q", arg)
+	}
+
+	return directive{name: name, arg: arg}, true, nil
+}
+
+// Return the line comment marker that line starts with, if any.
+func lineCommentPrefix(line string, comments commentSyntax) (string, bool) {
+	for _, prefix := range comments.line {
+		if strings.HasPrefix(line, prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
 }
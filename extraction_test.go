@@ -30,16 +30,32 @@ var cases = []RequireTest{
 	{`//= require extension.ext`, "", []string{"extension.ext"}},
 	{`//=require nospace`, "", []string{"nospace"}},
 	{`//=  require  manyspace`, "", []string{"manyspace"}},
+	{`//= require jquery-ui`, "", []string{"jquery-ui"}},
+	{`//= require vendor/backbone`, "", []string{"vendor/backbone"}},
+	{`//= require lodash.min-4`, "", []string{"lodash.min-4"}},
+	{`//= require "with space/@scope+x~1"`, "", []string{"with space/@scope+x~1"}},
+	{"//=======\n// banner\n//= require a", "//=======\n// banner\n", []string{"a"}},
 
 	{"//= require first\n//= require second", "", []string{"first", "second"}},
 
 	{"//= require dep\nother content", "other content", []string{"dep"}},
 }
 
+func args(directives []directive) []string {
+	result := make([]string, len(directives))
+	for i, d := range directives {
+		result[i] = d.arg
+	}
+	return result
+}
+
 func TestRequires(t *testing.T) {
 	for _, c := range cases {
-		content, requires := extractDependencies(c.rawContent)
-		if !eq(requires, c.requires) {
+		content, directives, err := extractDependencies(c.rawContent, "js")
+		if err != nil {
+			t.Errorf("extractDependencies(%q) failed: %s", c.rawContent, err)
+		}
+		if requires := args(directives); !eq(requires, c.requires) {
 			t.Errorf("extractDependencies(%q) requires = %v, want %v", c.content, requires, c.requires)
 		}
 		if content != c.content {
@@ -47,3 +63,134 @@ func TestRequires(t *testing.T) {
 		}
 	}
 }
+
+func TestDirectiveNames(t *testing.T) {
+	raw := "//= require a\n//= require_tree .\n//= require_directory lib\n//= require_self\n//= stub vendor\n"
+	_, directives, err := extractDependencies(raw, "js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []directive{
+		{"require", "a", 1},
+		{"require_tree", ".", 2},
+		{"require_directory", "lib", 3},
+		{"require_self", "", 4},
+		{"stub", "vendor", 5},
+	}
+	if len(directives) != len(expected) {
+		t.Fatalf("extractDependencies(%q) = %v, want %v", raw, directives, expected)
+	}
+	for i := range expected {
+		if directives[i] != expected[i] {
+			t.Errorf("extractDependencies(%q)[%d] = %v, want %v", raw, i, directives[i], expected[i])
+		}
+	}
+}
+
+type SyntaxTest struct {
+	filename   string
+	rawContent string
+	content    string
+	requires   []string
+}
+
+var syntaxCases = []SyntaxTest{
+	{"app.css", "/*= require reset */\nbody {}", "body {}", []string{"reset"}},
+	{"app.css", "/*\n *= require reset\n *= require base\n */\nbody {}", "/*\n */\nbody {}", []string{"reset", "base"}},
+	{"app.css", "/*\n *= require reset\n */\n", "/*\n */\n", []string{"reset"}},
+	{"app.less", "//= require mixins\n.a {}", ".a {}", []string{"mixins"}},
+	{"app.js.coffee", "#= require jquery\nclass Foo", "class Foo", []string{"jquery"}},
+	{"app.js.coffee", "//= require jquery\n", "//= require jquery\n", []string{}},
+	{"app.js.tmpl", "/*= require f */\n", "", []string{"f"}},
+	{"app.css", "#= require nope\n", "#= require nope\n", []string{}},
+	{"image.jpg", "//= require nope\n", "//= require nope\n", []string{}},
+}
+
+func TestDirectiveSyntax(t *testing.T) {
+	for _, c := range syntaxCases {
+		content, directives, err := extractDependencies(c.rawContent, directiveSyntax(c.filename))
+		if err != nil {
+			t.Errorf("extractDependencies(%q) in %s failed: %s", c.rawContent, c.filename, err)
+		}
+		if requires := args(directives); !eq(requires, c.requires) {
+			t.Errorf("extractDependencies(%q) in %s requires = %v, want %v", c.rawContent, c.filename, requires, c.requires)
+		}
+		if content != c.content {
+			t.Errorf("extractDependencies(%q) in %s content = %q, want %q", c.rawContent, c.filename, content, c.content)
+		}
+	}
+}
+
+var headerCases = []RequireTest{
+	{"// a comment\n//= require a\n\nvar a;\n//= require b\n", "// a comment\nvar a;\n//= require b\n", []string{"a"}},
+	{"var s = '//= require a';\n", "var s = '//= require a';\n", []string{}},
+	{"\n\n//= require a\n", "\n\n", []string{"a"}},
+	{"/* license */\n//= require a\ncode();", "/* license */\ncode();", []string{"a"}},
+	{"/*\n * license\n *= require a */\ncode();", "/*\n * license\n */\ncode();", []string{"a"}},
+	{"/*= require a\n * license\n */\ncode();", "/*\n * license\n */\ncode();", []string{"a"}},
+	{"/*\n\n*= require a\n\n*/\n//= require b\ncode();", "/*\n\n*/\ncode();", []string{"a", "b"}},
+	{"code();\n/*\n *= require a\n */\n", "code();\n/*\n *= require a\n */\n", []string{}},
+}
+
+func TestHeader(t *testing.T) {
+	for _, c := range headerCases {
+		content, directives, err := extractDependencies(c.rawContent, "js")
+		if err != nil {
+			t.Errorf("extractDependencies(%q) failed: %s", c.rawContent, err)
+		}
+		if requires := args(directives); !eq(requires, c.requires) {
+			t.Errorf("extractDependencies(%q) requires = %v, want %v", c.rawContent, requires, c.requires)
+		}
+		if content != c.content {
+			t.Errorf("extractDependencies(%q) content = %q, want %q", c.rawContent, content, c.content)
+		}
+	}
+}
+
+func TestDirectiveLines(t *testing.T) {
+	raw := "###\nA header\n= require a\n###\n\n#= require b\nclass Foo\n"
+	_, directives, err := extractDependencies(raw, "coffee")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []directive{{"require", "a", 3}, {"require", "b", 6}}
+	if len(directives) != len(expected) {
+		t.Fatalf("extractDependencies(%q) = %v, want %v", raw, directives, expected)
+	}
+	for i := range expected {
+		if directives[i] != expected[i] {
+			t.Errorf("extractDependencies(%q)[%d] = %v, want %v", raw, i, directives[i], expected[i])
+		}
+	}
+}
+
+var malformedCases = []struct {
+	rawContent string
+	line       int
+}{
+	{"//= require", 1},
+	{"//= require ''", 1},
+	{"// ok\n//= require \"unterminated", 2},
+	{"//= require 'mismatched\"", 1},
+	{"//= require one two", 1},
+	{"//= require \"one\" two", 1},
+	{"//= require_self please", 1},
+	{"\n\n//= requrie typo", 3},
+	{"/*\n *= require\n */", 2},
+}
+
+func TestMalformedDirectives(t *testing.T) {
+	for _, c := range malformedCases {
+		_, _, err := extractDependencies(c.rawContent, "js")
+		syntaxErr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("extractDependencies(%q) error = %v, want a *SyntaxError", c.rawContent, err)
+			continue
+		}
+		if syntaxErr.Line != c.line {
+			t.Errorf("extractDependencies(%q) error line = %d, want %d", c.rawContent, syntaxErr.Line, c.line)
+		}
+	}
+}
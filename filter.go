@@ -2,8 +2,11 @@ package monk
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -16,6 +19,12 @@ type AssetProcessor interface {
 	CheckSystem() error
 }
 
+// assetProcessor is implemented by filters that record information on the asset
+// whose content they are processing.
+type assetProcessor interface {
+	processAsset(context *Context, asset *Asset, content string, extension string) (string, error)
+}
+
 type AssetFilter struct{}
 
 func (af AssetFilter) CheckSystem() error {
@@ -33,6 +42,77 @@ func (af AssetFilter) RequireBin(bin string) error {
 	return nil
 }
 
+// Runs bin with content on its standard input and returns its standard output. If
+// the command fails, a *FilterError for extension is returned that includes
+// anything the command wrote to standard error.
+func (af AssetFilter) RunCommand(extension string, content string, bin string, args ...string) (string, error) {
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = strings.NewReader(content)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", &FilterError{Extension: extension, Stderr: stderr.String(), Err: err}
+	}
+	return out.String(), nil
+}
+
+// Writes content to the file input in a temporary directory, and runs bin there.
+// Returns the contents of the files named by outputs once the command completes.
+func (af AssetFilter) RunCommandInDir(extension string, content string, input string, outputs []string, bin string, args ...string) ([]string, error) {
+	dir, err := ioutil.TempDir("", "monk")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, input), []byte(content), 0644); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, &FilterError{Extension: extension, Stderr: stderr.String(), Err: err}
+	}
+
+	results := make([]string, len(outputs))
+	for i, output := range outputs {
+		result, err := ioutil.ReadFile(filepath.Join(dir, output))
+		if err != nil {
+			return nil, &FilterError{Extension: extension, Stderr: stderr.String(), Err: err}
+		}
+		results[i] = string(result)
+	}
+	return results, nil
+}
+
+// Runs a compiler that writes its output and a source map to the files named
+// output and output.map, and removes the sourceMappingURL comment it leaves in
+// the output.
+func (af AssetFilter) compileWithSourceMap(extension string, content string, input string, output string, bin string, args ...string) (string, *SourceMap, error) {
+	results, err := af.RunCommandInDir(extension, content, input, []string{output, output + ".map"}, bin, args...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sourceMap, err := parseSourceMap([]byte(results[1]))
+	if err != nil {
+		return "", nil, &FilterError{Extension: extension, Err: err}
+	}
+
+	lines := strings.SplitAfter(results[0], "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.Contains(lines[i], "sourceMappingURL=") {
+			lines = lines[:i]
+			break
+		}
+	}
+	return strings.Join(lines, ""), sourceMap, nil
+}
+
 func init() {
 	AppendFilter("coffee", &CoffeeFilter{})
 	AppendFilter("less", &LessFilter{})
@@ -51,12 +131,12 @@ type CoffeeFilter struct {
 }
 
 func (cf CoffeeFilter) Process(context *Context, content string, extension string) (string, error) {
-	cmd := exec.Command("coffee", "-s", "-c")
-	cmd.Stdin = strings.NewReader(content)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
-	return out.String(), err
+	return cf.RunCommand(extension, content, "coffee", "-s", "-c")
+}
+
+func (cf CoffeeFilter) ProcessWithSourceMap(context *Context, content string, extension string) (string, *SourceMap, error) {
+	return cf.compileWithSourceMap(extension, content, "input.coffee", "input.js",
+		"coffee", "-c", "-m", "input.coffee")
 }
 
 func (cf CoffeeFilter) CheckSystem() error {
@@ -68,12 +148,12 @@ type LessFilter struct {
 }
 
 func (lf LessFilter) Process(context *Context, content string, extension string) (string, error) {
-	cmd := exec.Command("lessc", "-", "--compress")
-	cmd.Stdin = strings.NewReader(content)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
-	return out.String(), err
+	return lf.RunCommand(extension, content, "lessc", "-", "--compress")
+}
+
+func (lf LessFilter) ProcessWithSourceMap(context *Context, content string, extension string) (string, *SourceMap, error) {
+	return lf.compileWithSourceMap(extension, content, "input.less", "input.css",
+		"lessc", "--compress", "--source-map=input.css.map", "input.less", "input.css")
 }
 
 func (lf LessFilter) CheckSystem() error {
@@ -83,23 +163,30 @@ func (lf LessFilter) CheckSystem() error {
 type TemplateFilter struct{}
 
 func (tf TemplateFilter) Process(context *Context, content string, extension string) (string, error) {
+	return tf.processAsset(context, nil, content, extension)
+}
+
+// Referencing another asset with the url or integrity helpers links it to asset.
+func (tf TemplateFilter) processAsset(context *Context, asset *Asset, content string, extension string) (string, error) {
 	tmpl := template.New("asset")
 
 	helpers := template.FuncMap{
 		"url": func(logicalPath string) (string, error) {
-
-			absPath, _, err := context.findPathInSearchPaths(logicalPath)
-			if err != nil {
+			if _, _, err := context.findPathInSearchPaths(logicalPath); err != nil {
 				return "", err
 			}
 
+			if asset != nil {
+				asset.link(logicalPath)
+			}
+
 			dir, file := filepath.Split(logicalPath)
 			extension := filepath.Ext(file)
 			basename := file[:len(file)-len(extension)]
 			root := context.Config.AssetRoot
 
 			if context.Config.Fingerprint {
-				fp, err := GenerateFingerprint(context.fs, absPath)
+				fp, err := context.digest(logicalPath)
 				if err != nil {
 					return "", err
 				}
@@ -109,6 +196,14 @@ func (tf TemplateFilter) Process(context *Context, content string, extension str
 				return fmt.Sprintf("%s%s%s%s", root, dir, basename, extension), nil
 			}
 		},
+		"integrity": func(logicalPath string) (template.HTML, error) {
+			if asset != nil {
+				asset.link(logicalPath)
+			}
+			// Integrity values are base64 encoded, so they must not be escaped.
+			value, err := context.Integrity(logicalPath)
+			return template.HTML(value), err
+		},
 	}
 
 	tmpl.Funcs(helpers)
@@ -128,8 +223,34 @@ func (tf TemplateFilter) CheckSystem() error {
 }
 
 func ApplyFilter(context *Context, content string, extension string) (string, error) {
-	if filter, ok := filters[extension]; ok {
-		return filter.Process(context, content, extension)
+	filtered, _, err := applyFilter(context, nil, content, extension)
+	return filtered, err
+}
+
+// Returns the filtered content, along with a source map when source maps are
+// enabled and the filter can produce one. Errors returned by the filter are wrapped
+// in a *FilterError if needed.
+func applyFilter(context *Context, asset *Asset, content string, extension string) (string, *SourceMap, error) {
+	filter, ok := filters[extension]
+	if !ok {
+		err := fmt.Errorf("could not find a filter for extension: %q", extension)
+		return "", nil, &FilterError{Extension: extension, Err: err}
+	}
+
+	var filtered string
+	var sourceMap *SourceMap
+	var err error
+	if processor, ok := filter.(SourceMapProcessor); ok && context != nil && context.Config.SourceMaps {
+		filtered, sourceMap, err = processor.ProcessWithSourceMap(context, content, extension)
+	} else if processor, ok := filter.(assetProcessor); ok && asset != nil {
+		filtered, err = processor.processAsset(context, asset, content, extension)
+	} else {
+		filtered, err = filter.Process(context, content, extension)
+	}
+
+	var filterErr *FilterError
+	if err != nil && !errors.As(err, &filterErr) {
+		err = &FilterError{Extension: extension, Err: err}
 	}
-	return "", fmt.Errorf("could not find a filter for extension: %q", extension)
+	return filtered, sourceMap, err
 }
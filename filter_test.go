@@ -33,6 +33,20 @@ func templateFilterCompare(c *Context, t *testing.T, before string, expected str
 
 }
 
+// Registers filter for extension until the end of the test, restoring any filter
+// it replaces.
+func useFilter(t *testing.T, extension string, filter AssetProcessor) {
+	previous, ok := filters[extension]
+	AppendFilter(extension, filter)
+	t.Cleanup(func() {
+		if ok {
+			filters[extension] = previous
+		} else {
+			delete(filters, extension)
+		}
+	})
+}
+
 func TestTemplateFilter(t *testing.T) {
 	input := `url('{{url "lolcat.png"}}')`
 
@@ -1,36 +1,220 @@
 package monk
 
 import (
-	"crypto/md5"
+	"bytes"
+	"crypto"
+	_ "crypto/md5"
+	_ "crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"fmt"
+	"hash"
+	"sync"
 	"time"
 )
 
 type fingerprint struct {
 	modtime time.Time
-	hash    string
+	size    int64
+
+	// The digest of the file computed with each algorithm that has been asked for.
+	hashes map[crypto.Hash]string
+}
+
+// A FingerprintCache remembers the fingerprints of each file it is asked about, keyed
+// by path. A cached fingerprint is only used while the file's modification time and
+// size are unchanged, so files that are edited are hashed again. It is safe for
+// concurrent use.
+type FingerprintCache struct {
+	mu           sync.RWMutex
+	fingerprints map[string]*fingerprint
 }
 
-var fingerprintCache = make(map[string]*fingerprint)
+// The cache used by GenerateFingerprint.
+var fingerprintCache = NewFingerprintCache()
 
-// This function is not safe to call concurrently.
-func GenerateFingerprint(fs fileSystem, path string) (string, error) {
-	if fp, ok := fingerprintCache[path]; ok {
-		return fp.hash, nil
+func NewFingerprintCache() *FingerprintCache {
+	return &FingerprintCache{fingerprints: make(map[string]*fingerprint)}
+}
+
+// Returns the fingerprint of the file at path computed with algorithm, reading it
+// from fs only if it is not cached or has changed since it was cached.
+func (c *FingerprintCache) Fingerprint(fs fileSystem, path string, algorithm crypto.Hash) (string, error) {
+	info, err := fs.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	current := func(fp *fingerprint) bool {
+		return fp.modtime.Equal(info.ModTime()) && fp.size == info.Size()
 	}
 
+	c.mu.RLock()
+	fp, ok := c.fingerprints[path]
+	if ok && current(fp) {
+		if hash, ok := fp.hashes[algorithm]; ok {
+			c.mu.RUnlock()
+			return hash, nil
+		}
+	}
+	c.mu.RUnlock()
+
 	content, err := fs.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	hash, err := digest(algorithm, content)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fp, ok = c.fingerprints[path]
+	if !ok || !current(fp) {
+		fp = &fingerprint{modtime: info.ModTime(), size: info.Size(), hashes: map[crypto.Hash]string{}}
+		c.fingerprints[path] = fp
+	}
+	fp.hashes[algorithm] = hash
+	return hash, nil
+}
+
+// Forget the fingerprint of the file at path.
+func (c *FingerprintCache) Invalidate(path string) {
+	c.mu.Lock()
+	delete(c.fingerprints, path)
+	c.mu.Unlock()
+}
+
+// Forget every cached fingerprint.
+func (c *FingerprintCache) Reset() {
+	c.mu.Lock()
+	c.fingerprints = make(map[string]*fingerprint)
+	c.mu.Unlock()
+}
+
+// Returns the MD5 fingerprint of the file at path, using a cache shared by the whole
+// package. It is safe to call concurrently.
+func GenerateFingerprint(fs fileSystem, path string) (string, error) {
+	return fingerprintCache.Fingerprint(fs, path, crypto.MD5)
+}
 
+// Forget the cached fingerprint of the file at path, so that the next call to
+// GenerateFingerprint reads it again.
+func InvalidateFingerprint(path string) {
+	fingerprintCache.Invalidate(path)
+}
+
+// Forget every fingerprint cached by GenerateFingerprint.
+func ResetFingerprints() {
+	fingerprintCache.Reset()
+}
+
+// Returns a new hash.Hash computing algorithm, or an error if it is not linked into
+// the binary.
+func newHash(algorithm crypto.Hash) (hash.Hash, error) {
+	if !algorithm.Available() {
+		return nil, fmt.Errorf("digest algorithm %v is not available", algorithm)
+	}
+	return algorithm.New(), nil
+}
+
+// Returns the hex encoded digest of content computed with algorithm.
+func digest(algorithm crypto.Hash, content []byte) (string, error) {
+	h, err := newHash(algorithm)
 	if err != nil {
 		return "", err
 	}
+	h.Write(content)
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Returns a Subresource Integrity value for content, as used in the integrity
+// attribute of script and link tags.
+func integrity(content []byte) string {
+	sum := sha512.Sum384(content)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
 
-	hash := md5.New()
-	_, err = hash.Write(content)
+// Returns a digest of the bundle built from r. Unlike the fingerprint of a single
+// file, it covers the processed content of every asset in the bundle, the files those
+// assets depend on and the configuration used to build them, so it changes whenever
+// the bundle or any URL it contains might.
+//
+// Files that are built unchanged, such as images, are digested by their content
+// alone, so that their URLs only change when they do.
+func BundleDigest(r *Resolution, context *Context) (string, error) {
+	config := context.Config
 
+	if len(r.Resolved) == 1 && !hasHeaders(r) {
+		asset, err := context.lookup(r.Resolved[0])
+		if err != nil {
+			return "", err
+		}
+		if asset.Content == asset.source && len(asset.DependOn) == 0 {
+			return fingerprintCache.Fingerprint(context.fs, asset.Path, config.Digest)
+		}
+	}
+	h, err := newHash(config.Digest)
 	if err != nil {
 		return "", err
 	}
+	fmt.Fprintf(h, "config fingerprint=%t asset_root=%q source_maps=%t\n",
+		config.Fingerprint, config.AssetRoot, config.SourceMaps)
+
+	for _, logicalPath := range r.Resolved {
+		asset, err := context.lookup(logicalPath)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "asset %q %d\n%s", logicalPath, len(asset.Content), asset.Content)
+
+		for _, dependency := range asset.DependOn {
+			absPath, _, err := context.findPathInSearchPaths(dependency)
+			if err != nil {
+				return "", err
+			}
+			fp, err := fingerprintCache.Fingerprint(context.fs, absPath, config.Digest)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "depend_on %q %s\n", dependency, fp)
+		}
+	}
 
-	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Returns the digest of the bundle built from the asset at logicalPath.
+func (c *Context) digest(logicalPath string) (string, error) {
+	r := &Resolution{}
+	if err := r.Resolve(logicalPath, c); err != nil {
+		return "", err
+	}
+	return BundleDigest(r, c)
+}
+
+// Returns the Subresource Integrity value of the bundle built from the asset at
+// logicalPath, as served from the URL that the url template helper returns for it.
+func (c *Context) Integrity(logicalPath string) (string, error) {
+	r := &Resolution{}
+	if err := r.Resolve(logicalPath, c); err != nil {
+		return "", err
+	}
+
+	// Fingerprinted URLs refer to precompiled files, which differ from other bundles
+	// in the name of their source map. LocalCache serves them the same way.
+	if c.Config.Fingerprint {
+		compiled, err := compile(r, c)
+		if err != nil {
+			return "", err
+		}
+		return integrity(compiled.content), nil
+	}
+
+	var out bytes.Buffer
+	if err := BuildTo(&out, r, c); err != nil {
+		return "", err
+	}
+	return integrity(out.Bytes()), nil
 }
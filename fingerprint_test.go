@@ -1,7 +1,11 @@
 package monk
 
 import (
+	"crypto"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestGenerateFingerprint(t *testing.T) {
@@ -20,3 +24,183 @@ func TestGenerateFingerprint(t *testing.T) {
 		t.Errorf("expected %q, got: %q", expected, fingerprint)
 	}
 }
+
+func TestFingerprintCache(t *testing.T) {
+	cache := NewFingerprintCache()
+	modTime := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	fs := NewTestFS()
+	write := func(content string, modTime time.Time) {
+		fs.File("notes", content)
+		info := fs.files["notes"].info.(TestFileInfo)
+		info.modTime = modTime
+		fs.files["notes"].info = info
+	}
+
+	write("all in due time\n", modTime)
+	original, err := cache.Fingerprint(fs, "notes", crypto.MD5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Content changed without touching the modification time or size is not noticed.
+	write("all in due tide\n", modTime)
+	if fp, _ := cache.Fingerprint(fs, "notes", crypto.MD5); fp != original {
+		t.Errorf("expected the cached fingerprint %q, got: %q", original, fp)
+	}
+
+	cache.Invalidate("notes")
+	invalidated, _ := cache.Fingerprint(fs, "notes", crypto.MD5)
+	if invalidated == original {
+		t.Errorf("expected a new fingerprint after Invalidate, got: %q", invalidated)
+	}
+
+	write("all in due time\n", modTime.Add(time.Second))
+	if fp, _ := cache.Fingerprint(fs, "notes", crypto.MD5); fp != original {
+		t.Errorf("expected %q once the modification time changed, got: %q", original, fp)
+	}
+
+	write("all in good time\n", modTime.Add(time.Second))
+	changed, _ := cache.Fingerprint(fs, "notes", crypto.MD5)
+	if changed == original {
+		t.Errorf("expected a new fingerprint once the size changed, got: %q", changed)
+	}
+
+	cache.Reset()
+	if len(cache.fingerprints) != 0 {
+		t.Errorf("expected Reset to empty the cache, got: %v", cache.fingerprints)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if fp, err := cache.Fingerprint(fs, "notes", crypto.MD5); err != nil || fp != changed {
+				t.Errorf("expected %q, got: %q, %v", changed, fp, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestBundleDigest(t *testing.T) {
+	bundleDigest := func(files map[string]string, configure func(*Config)) string {
+		fs := NewTestFS()
+		for name, content := range files {
+			fs.File(name, content)
+		}
+		context := NewContext(fs)
+		context.SearchPath("assets")
+		if configure != nil {
+			configure(context.Config)
+		}
+
+		fp, err := context.digest("app.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+
+	files := map[string]string{
+		"assets/app.js":      "//= require lib\n//= depend_on config.json\napp();\n",
+		"assets/lib.js":      "lib();\n",
+		"assets/config.json": "{}",
+	}
+	original := bundleDigest(files, nil)
+
+	if fp := bundleDigest(files, nil); fp != original {
+		t.Errorf("expected the same digest for the same bundle, got: %q and %q", original, fp)
+	}
+
+	changes := map[string]map[string]string{
+		"required file": {"assets/lib.js": "lib(1);\n"},
+		"depend_on":     {"assets/config.json": "{\"debug\": true}"},
+	}
+	for name, changed := range changes {
+		modified := map[string]string{}
+		for file, content := range files {
+			modified[file] = content
+		}
+		for file, content := range changed {
+			modified[file] = content
+		}
+		if fp := bundleDigest(modified, nil); fp == original {
+			t.Errorf("expected a change to a %s to change the digest", name)
+		}
+	}
+
+	if fp := bundleDigest(files, func(c *Config) { c.AssetRoot = "/a/" }); fp == original {
+		t.Errorf("expected a change to the config to change the digest")
+	}
+}
+
+func TestBundleDigestOfUnchangedFiles(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/logo.png", "not really a png")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+	context.Config.AssetRoot = "/a/"
+	context.Config.SourceMaps = true
+
+	fp, err := context.digest("logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := GenerateFingerprint(fs, "assets/logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp != expected {
+		t.Errorf("expected files built unchanged to be digested by their content, %q, got: %q", expected, fp)
+	}
+}
+
+func TestDigestAlgorithm(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js", "app();\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+	context.Config.Digest = crypto.SHA256
+
+	fp, err := context.digest("app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fp) != 64 {
+		t.Errorf("expected a hex encoded SHA-256 digest, got: %q", fp)
+	}
+
+	if _, err := ParseDigest("crc32"); err == nil {
+		t.Errorf("expected an unknown digest algorithm to be rejected")
+	}
+	if algorithm, err := ParseDigest("sha384"); err != nil || algorithm != crypto.SHA384 {
+		t.Errorf("expected sha384 to be parsed, got: %v, %v", algorithm, err)
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js", "app();\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+
+	// The SHA-384 digest of the bundle "/* app.js */\napp();\n\n".
+	expected := "sha384-Jv1bGeMs2DMp201JSHROxbbY0LJnb+HNa6nQbHqwBDCpe9s8rjjwh/KoIPoXpS6U"
+	if value, err := context.Integrity("app.js"); err != nil || value != expected {
+		t.Errorf("expected %q, got: %q, %v", expected, value, err)
+	}
+
+	fs.File("assets/page.js.tmpl", `load('{{url "app.js"}}', '{{integrity "app.js"}}');`)
+	asset, err := context.lookup("page.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(asset.Content, expected) || !eq(asset.Links, []string{"app.js"}) {
+		t.Errorf("expected the integrity helper to link app.js, got: %q, %q", asset.Content, asset.Links)
+	}
+}
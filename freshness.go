@@ -0,0 +1,119 @@
+package monk
+
+// What an asset was built from, recorded when it is created so that a long-lived
+// Context can tell whether it needs to be created again.
+type assetStamp struct {
+	// The generation of the Context in which the asset was last checked.
+	checked int
+
+	// The files found by each require_tree and require_directory directive.
+	trees []treeStamp
+
+	// The fingerprint of each entry in DependOn, as returned by
+	// dependencyFingerprint.
+	dependOn map[string]string
+}
+
+type treeStamp struct {
+	searchPath string
+	root       string
+	ext        string
+	recursive  bool
+	deps       []string
+}
+
+// Refresh makes each asset in the Store be checked for changes the next time it is
+// looked up, so that a long-lived Context only processes the files that changed
+// again. Without calling Refresh, assets are assumed not to change once created.
+//
+// An asset is considered changed when its logical path matches a different file,
+// its file's content changes, the files required by its require_tree or
+// require_directory directives change, or any file it depends on changes. When URLs
+// are fingerprinted, it is also considered changed when the bundle built from any
+// asset it links to changes.
+func (c *Context) Refresh() {
+	c.shared.mu.Lock()
+	c.shared.generation++
+	c.shared.mu.Unlock()
+}
+
+// Returns asset, which was created for logicalPath, if it is still up to date. If
+// its file was saved without being changed, a copy with the file's new FileInfo is
+// returned, since assets may be in use by other goroutines.
+func (c *Context) isFresh(logicalPath string, asset *Asset) (*Asset, bool) {
+	absPath, info, err := c.findPathInSearchPaths(logicalPath)
+	if err != nil || absPath != asset.Path {
+		return nil, false
+	}
+
+	if !info.ModTime().Equal(asset.ModTime()) || info.Size() != asset.Size() {
+		// Files that are saved without being changed don't need to be processed
+		// again.
+		content, err := c.fs.ReadFile(absPath)
+		if err != nil || string(content) != asset.source {
+			return nil, false
+		}
+		touched := *asset
+		touched.FileInfo = info
+		asset = &touched
+	}
+
+	for _, tree := range asset.stamp.trees {
+		deps, err := c.explodeDirectory(asset.Path, tree.searchPath, tree.root, tree.ext, tree.recursive)
+		if err != nil || !equalStrings(deps, tree.deps) {
+			return nil, false
+		}
+	}
+
+	for logicalPath, fingerprint := range asset.stamp.dependOn {
+		if c.dependencyFingerprint(asset, logicalPath) != fingerprint {
+			return nil, false
+		}
+	}
+
+	return asset, true
+}
+
+// Records the fingerprints of the files that asset depends on.
+func (c *Context) stampDependencies(asset *Asset) {
+	asset.stamp.dependOn = make(map[string]string, len(asset.DependOn))
+	for _, logicalPath := range asset.DependOn {
+		asset.stamp.dependOn[logicalPath] = c.dependencyFingerprint(asset, logicalPath)
+	}
+}
+
+// Returns the fingerprint of logicalPath, which asset depends on, or an empty
+// string if it can't be found. When URLs are fingerprinted, assets that asset links
+// to, such as with the url template helper, are fingerprinted by the digest of the
+// bundle built from them, since their URLs change whenever any of their own
+// dependencies do. Other files, and linked bundles that fail to build, are
+// fingerprinted by their content.
+func (c *Context) dependencyFingerprint(asset *Asset, logicalPath string) string {
+	if c.Config.Fingerprint && contains(logicalPath, asset.Links) {
+		if digest, err := c.digest(logicalPath); err == nil {
+			return digest
+		}
+	}
+
+	absPath, _, err := c.findPathInSearchPaths(logicalPath)
+	if err != nil {
+		return ""
+	}
+	fingerprint, err := fingerprintCache.Fingerprint(c.fs, absPath, c.Config.Digest)
+	if err != nil {
+		return ""
+	}
+	return fingerprint
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
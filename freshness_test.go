@@ -0,0 +1,175 @@
+package monk
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Counts the files it processes, to tell whether assets are processed again.
+type countingFilter struct {
+	AssetFilter
+	count int32
+}
+
+func (f *countingFilter) Process(context *Context, content string, extension string) (string, error) {
+	atomic.AddInt32(&f.count, 1)
+	return content, nil
+}
+
+var counter = &countingFilter{}
+
+func TestRefresh(t *testing.T) {
+	useFilter(t, "counted", counter)
+
+	fs := NewTestFS()
+	fs.File("assets/app.js.counted", "//= require_tree ./lib\n//= depend_on config.json\napp();\n")
+	fs.File("assets/lib/a.js", "a();\n")
+	fs.File("assets/config.json", "{}")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+
+	lookup := func(logicalPath string) *Asset {
+		asset, err := c.lookup(logicalPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return asset
+	}
+	app, a := lookup("app.js"), lookup("lib/a.js")
+
+	fs.File("assets/lib/a.js", "a(1);\n")
+	if lookup("lib/a.js") != a {
+		t.Errorf("expected assets to be kept until the Context is refreshed")
+	}
+
+	c.Refresh()
+	if changed := lookup("lib/a.js"); changed == a || changed.Content != "a(1);\n" {
+		t.Errorf("expected a changed file to be processed again, got: %q", changed.Content)
+	}
+	if lookup("app.js") != app {
+		t.Errorf("expected an unchanged asset to be kept")
+	}
+
+	// Saving a file without changing it doesn't make it stale.
+	c.Refresh()
+	count := atomic.LoadInt32(&counter.count)
+	fs.File("assets/app.js.counted", "//= require_tree ./lib\n//= depend_on config.json\napp();\n")
+	if touched := lookup("app.js"); atomic.LoadInt32(&counter.count) != count || touched.Content != app.Content {
+		t.Errorf("expected an asset whose file was saved without changes to not be processed again")
+	}
+
+	c.Refresh()
+	fs.File("assets/lib/b.js", "b();\n")
+	app = lookup("app.js")
+	if !eq(app.Dependencies, []string{"lib/a.js", "lib/b.js"}) {
+		t.Errorf("expected a new file in a required tree to be included, got: %q", app.Dependencies)
+	}
+
+	c.Refresh()
+	fs.File("assets/config.json", "{\"debug\": true}")
+	if lookup("app.js") == app {
+		t.Errorf("expected a change to a depend_on file to make the asset stale")
+	}
+
+	c.Refresh()
+	fs.File("assets/lib/b.js.coffee", "b()\n")
+	delete(fs.files, "assets/lib/b.js")
+	if b := lookup("lib/b.js"); b.Path != "assets/lib/b.js.coffee" {
+		t.Errorf("expected a logical path matching a different file to be processed again, got: %q", b.Path)
+	}
+
+	c.Refresh()
+	delete(fs.files, "assets/lib/a.js")
+	if _, err := c.lookup("lib/a.js"); err == nil {
+		t.Errorf("expected a deleted asset to not be found")
+	}
+}
+
+func TestRefreshLinks(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/page.html.tmpl", `<script src="{{url "app.js"}}"></script>`)
+	fs.File("assets/app.js", "//= require lib\napp();\n")
+	fs.File("assets/lib.js", "lib();\n")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+	c.Config.Fingerprint = true
+
+	page, err := c.lookup("page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Refresh()
+	fs.File("assets/lib.js", "lib(1);\n")
+	changed, err := c.lookup("page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == page || changed.Content == page.Content {
+		t.Errorf("expected a change to a dependency of a linked bundle to make the asset stale, got: %q", changed.Content)
+	}
+
+	digest, err := c.digest("app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(changed.Content, digest) {
+		t.Errorf("expected the current digest of app.js, %q, got: %q", digest, changed.Content)
+	}
+}
+
+func TestRefreshLinksWithoutFingerprints(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/page.html.tmpl", `<script src="{{url "app.js"}}"></script>`)
+	fs.File("assets/app.js", "//= require lib\napp();\n")
+	fs.File("assets/lib.js", "lib();\n")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+
+	page, err := c.lookup("page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// URLs without fingerprints don't change when the linked bundle does.
+	c.Refresh()
+	fs.File("assets/lib.js", "lib(1);\n")
+	if unchanged, err := c.lookup("page.html"); err != nil || unchanged != page {
+		t.Errorf("expected a change to a dependency of a linked bundle to be ignored, got: %v", err)
+	}
+	if _, ok := c.Store["app.js"]; ok {
+		t.Errorf("expected the linked bundle to not be built to check the asset")
+	}
+}
+
+func TestLocalCacheRefresh(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js", "app();\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+	cache := &LocalCache{Context: context}
+
+	read := func() string {
+		file, err := cache.Open("/app.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 100)
+		n, _ := file.Read(buf)
+		return string(buf[:n])
+	}
+
+	if content := read(); content != "/* app.js */\napp();\n\n" {
+		t.Errorf("unexpected content: %q", content)
+	}
+
+	fs.File("assets/app.js", "app(1);\n")
+	if content := read(); content != "/* app.js */\napp(1);\n\n" {
+		t.Errorf("expected changes to be served, got: %q", content)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 )
@@ -61,33 +62,70 @@ func (fs TestFS) Stat(name string) (os.FileInfo, error) {
 	if file, ok := fs.files[name]; ok {
 		return file.info, nil
 	}
-	return nil, &os.PathError{"stat", name, os.ErrNotExist}
+	if fs.isDir(name) {
+		return dirInfo(name), nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
 }
 
 func (fs TestFS) ReadFile(name string) ([]byte, error) {
 	if file, ok := fs.files[name]; ok {
 		return file.content, nil
 	}
-	return nil, &os.PathError{"stat", name, os.ErrNotExist}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
 }
 
 func (fs TestFS) Open(name string) (file, error) {
 	if file, ok := fs.files[name]; ok {
 		return file, nil
 	}
-	return nil, &os.PathError{"stat", name, os.ErrNotExist}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
 }
 
+// ReadDir returns the immediate children of name, sorted by name like
+// ioutil.ReadDir. Directories are implied by the paths of the files they contain.
 func (fs TestFS) ReadDir(name string) ([]os.FileInfo, error) {
 	files := []os.FileInfo{}
-	for path, file := range fs.files {
-		if strings.HasPrefix(path, name) {
-			files = append(files, file.info)
+	seen := map[string]bool{}
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for p, file := range fs.files {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(p, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			child := rest[:i]
+			if !seen[child] {
+				seen[child] = true
+				files = append(files, dirInfo(child))
+			}
+			continue
 		}
+		files = append(files, file.info)
 	}
 	if len(files) == 0 {
-		err := &os.PathError{"stat", name, os.ErrNotExist}
+		err := &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
 		return files, err
 	}
+	sort.Sort(byName(files))
 	return files, nil
 }
+
+func (fs TestFS) isDir(name string) bool {
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for p := range fs.files {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func dirInfo(name string) TestFileInfo {
+	return TestFileInfo{
+		name:    path.Base(name),
+		mode:    os.ModeDir | 0777,
+		modTime: time.Now(),
+		isDir:   true,
+	}
+}
@@ -0,0 +1,142 @@
+package monk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A LiveReload is an http.Handler that tells browsers which assets have changed,
+// as found by its Watcher, using Server-Sent Events. Each change is sent as a
+// change event whose data is a JSON array of logical paths.
+//
+// Browsers connect to it using the script returned by LiveReloadScript, which
+// pages include from a LiveReloadScriptHandler.
+type LiveReload struct {
+	Watcher *Watcher
+}
+
+func (lr *LiveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	changes := lr.Watcher.Subscribe()
+	defer lr.Watcher.Unsubscribe(changes)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// Browsers reconnect automatically, such as when the server is restarted.
+	fmt.Fprint(w, "retry: 1000\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(changedLogicalPaths(lr.Watcher.context, change))
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: change\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Returns the logical paths of the files in change, along with those of the assets
+// that were invalidated because of them, in lexical order.
+func changedLogicalPaths(context *Context, change Change) []string {
+	result := append([]string{}, change.LogicalPaths...)
+	for _, absPath := range change.Paths {
+		searchPath, ok := context.searchPathFor(absPath)
+		if !ok || absPath == searchPath || path.Ext(absPath) == "" {
+			continue
+		}
+		logicalPath := logicalPathFor(strings.TrimPrefix(absPath, searchPath+"/"))
+		if !contains(logicalPath, result) {
+			result = append(result, logicalPath)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Returns a script that connects to the LiveReload handler at url. When a
+// stylesheet changes, any link to it is reloaded in place, and when a script
+// changes the page is reloaded. assetRoot is the root that assets are linked to
+// from the page, and fingerprints are removed from links when they are reloaded.
+//
+// The script only connects once per page, however many times it is included.
+func LiveReloadScript(url string, assetRoot string) string {
+	encode := func(s string) string {
+		data, _ := json.Marshal(s)
+		return string(data)
+	}
+	return fmt.Sprintf(liveReloadScript, encode(url), encode(assetRoot))
+}
+
+// Returns an http.Handler that serves the script returned by LiveReloadScript, for
+// pages to include with a script tag. It is served separately from assets, so that
+// they are served unchanged and match their integrity values.
+func LiveReloadScriptHandler(url string, assetRoot string) http.Handler {
+	script := LiveReloadScript(url, assetRoot)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("Cache-Control", "no-cache")
+		io.WriteString(w, script)
+	})
+}
+
+const liveReloadScript = `
+;(function() {
+  if (window.monkLiveReload || !window.EventSource) return;
+  window.monkLiveReload = true;
+
+  var url = %s, root = %s;
+
+  function logicalPath(href) {
+    var link = document.createElement("a");
+    link.href = href;
+    var pathname = link.pathname.replace(/-[0-9a-f]{32,128}(\.[^.\/]*)$/, "$1");
+    var prefix = root.replace(/^[a-z]+:\/\/[^\/]+/, "");
+    return pathname.indexOf(prefix) === 0 ? pathname.slice(prefix.length) : null;
+  }
+
+  function reloadStylesheet(path) {
+    var links = document.querySelectorAll("link[rel=stylesheet]");
+    for (var i = 0; i < links.length; i++) {
+      if (logicalPath(links[i].href) === path) {
+        links[i].href = root + path + "?livereload=" + Date.now();
+      }
+    }
+  }
+
+  new EventSource(url).addEventListener("change", function(event) {
+    var paths = JSON.parse(event.data);
+    for (var i = 0; i < paths.length; i++) {
+      if (/\.js$/.test(paths[i])) {
+        window.location.reload();
+        return;
+      }
+    }
+    for (var i = 0; i < paths.length; i++) {
+      if (/\.css$/.test(paths[i])) reloadStylesheet(paths[i]);
+    }
+  });
+})();
+`
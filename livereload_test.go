@@ -0,0 +1,82 @@
+package monk
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLiveReload(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.css", "//= require lib\n")
+	fs.File("assets/lib.css", "lib {}\n")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+	if _, err := c.lookup("app.css"); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := NewWatcher(c)
+	if _, err := watcher.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(&LiveReload{Watcher: watcher})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("expected an event stream, got: %q", contentType)
+	}
+
+	events := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		var event []string
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return strings.Join(event, "")
+			}
+			event = append(event, line)
+		}
+	}
+
+	// The stream starts once the handler has subscribed to the watcher.
+	if event := readEvent(); event != "retry: 1000\n" {
+		t.Errorf("unexpected first event: %q", event)
+	}
+
+	fs.File("assets/lib.css", "lib { color: red; }\n")
+	fs.File("assets/logo.png", "not really a png")
+	if _, err := watcher.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "event: change\ndata: [\"app.css\",\"lib.css\",\"logo.png\"]\n"
+	if event := readEvent(); event != expected {
+		t.Errorf("expected %q, got: %q", expected, event)
+	}
+}
+
+func TestLiveReloadScript(t *testing.T) {
+	script := LiveReloadScript("/assets/livereload", "/assets/")
+	if !strings.Contains(script, `"/assets/livereload"`) || !strings.Contains(script, "EventSource") {
+		t.Errorf("unexpected script: %q", script)
+	}
+
+	w := httptest.NewRecorder()
+	LiveReloadScriptHandler("/assets/livereload", "/assets/").ServeHTTP(w, httptest.NewRequest("GET", "/assets/livereload.js", nil))
+	if w.Body.String() != script || w.Header().Get("Content-Type") != "application/javascript" {
+		t.Errorf("expected the script to be served as JavaScript, got: %q %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
@@ -2,13 +2,34 @@ package monk
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"path"
+	"strings"
+	"sync"
 	"time"
 )
 
-type LocalCache struct{}
+// A LocalCache is an http.Handler and http.FileSystem that builds assets as they are
+// requested. When Config.SourceMaps is enabled, the source map of each bundle is
+// served from the bundle's path with .map appended.
+//
+// Fingerprinted paths, such as those returned by the url template helper when
+// Config.Fingerprint is enabled, are served only while the fingerprint matches the
+// asset's current digest. Requests for outdated fingerprints are reported as not
+// existing, so they are answered with a 404.
+type LocalCache struct {
+	// The Context used to build assets. It is kept for the life of the LocalCache
+	// and refreshed before each request, so that only the files that have changed
+	// are processed again. If nil, a Context that finds assets in the assets
+	// directory alongside this package is created on first use.
+	Context *Context
+
+	once sync.Once
+}
 
 type CachedFileInfo struct {
 	name    string
@@ -25,28 +46,210 @@ func (f CachedFileInfo) ModTime() time.Time { return f.modTime }
 func (f CachedFileInfo) IsDir() bool        { return f.isDir }
 func (f CachedFileInfo) Sys() interface{}   { return nil }
 
-func (lc *LocalCache) Open(name string) (file http.File, err error) {
-	content, err := Get(name)
+// Serves the asset named by the request's path. Responses carry the asset's digest
+// as their ETag and the latest modification time of the files it was built from as
+// their Last-Modified date, so conditional requests are answered with a 304 when the
+// asset has not changed. Fingerprinted requests are marked as cacheable forever,
+// since any change to the asset changes its URL; other requests must be revalidated.
+func (lc *LocalCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	name = path.Clean(name)
+
+	file, err := lc.open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("ETag", `"`+file.digest+`"`)
+	if path.Ext(name) == ".map" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if file.fingerprinted {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	// Let http.FileServer handle conditional and range requests, without building
+	// the asset again.
+	http.FileServer(openedFile{name, file}).ServeHTTP(w, r)
+}
+
+func (lc *LocalCache) Open(name string) (http.File, error) {
+	file, err := lc.open(name)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (lc *LocalCache) open(name string) (file CachedFile, err error) {
+	context := lc.context()
+	context.Refresh()
+
+	// Bundles refer to their source maps by their own name with .map appended.
+	root := strings.TrimPrefix(name, "/")
+	sourceMap := context.Config.SourceMaps && path.Ext(root) == ".map"
+	if sourceMap {
+		root = strings.TrimSuffix(root, ".map")
+	}
+
+	logicalPath, fingerprinted, err := lc.logicalPath(context, root)
+	if err != nil {
+		return
+	}
+
+	r := &Resolution{}
+	var content string
+	if err = r.Resolve(logicalPath, context); err == nil {
+		content, err = build(r, context, fingerprinted, sourceMap)
+	}
+	if err != nil {
+		var notFound *NotFoundError
+		if errors.As(err, &notFound) && notFound.LogicalPath == logicalPath {
+			err = &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+			return
+		}
+		fmt.Printf("%s\n", err.Error())
+		return
+	}
+
+	fingerprint, err := BundleDigest(r, context)
+	if err != nil {
+		return
+	}
+	modTime, err := lastModified(r, context)
 	if err != nil {
-		fmt.Printf("%s", err.Error())
 		return
 	}
 
 	info := &CachedFileInfo{
-		name:    name,
+		name:    path.Base(name),
 		size:    int64(len(content)),
 		mode:    0777,
-		modTime: time.Now(),
+		modTime: modTime,
 		isDir:   false,
 	}
-	file = CachedFile{info: info, buffer: bytes.NewBufferString(content)}
+	file = CachedFile{
+		info:          info,
+		reader:        bytes.NewReader([]byte(content)),
+		digest:        fingerprint,
+		fingerprinted: fingerprinted,
+	}
 
 	return
 }
 
+func (lc *LocalCache) context() *Context {
+	lc.once.Do(func() {
+		if lc.Context == nil {
+			lc.Context = newAssetsContext()
+		}
+	})
+	return lc.Context
+}
+
+// Returns the logical path of the asset requested as name, and whether name was
+// fingerprinted. If it was, the fingerprint is removed once it has been checked
+// against the asset's digest. A name that only looks fingerprinted, because no asset
+// matches it once the fingerprint is removed, is returned unchanged.
+func (lc *LocalCache) logicalPath(context *Context, name string) (string, bool, error) {
+	logicalPath, fingerprint, ok := splitDigestedPath(name, context.Config.Digest)
+	if !ok {
+		return name, false, nil
+	}
+
+	current, err := context.digest(logicalPath)
+	var notFound *NotFoundError
+	if errors.As(err, &notFound) && notFound.LogicalPath == logicalPath {
+		return name, false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	if fingerprint != current {
+		return "", false, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return logicalPath, true, nil
+}
+
+// Returns the bundle built from r, or its source map encoded as JSON. Fingerprinted
+// paths are served the files that Manifest.Compile writes, which refer to their
+// source maps by their fingerprinted names, so that they match their integrity
+// values.
+func build(r *Resolution, context *Context, fingerprinted bool, sourceMap bool) (string, error) {
+	if fingerprinted {
+		compiled, err := compile(r, context)
+		if err != nil {
+			return "", err
+		}
+		if sourceMap {
+			return string(compiled.sourceMap), nil
+		}
+		return string(compiled.content), nil
+	}
+
+	if !sourceMap {
+		return Build(r, context)
+	}
+	sm, err := BuildSourceMap(r, context)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(sm)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Returns the latest modification time of the files that the assets in r were
+// built from.
+func lastModified(r *Resolution, context *Context) (time.Time, error) {
+	var modTime time.Time
+	for _, logicalPath := range r.Resolved {
+		asset, err := context.lookup(logicalPath)
+		if err != nil {
+			return modTime, err
+		}
+		if asset.ModTime().After(modTime) {
+			modTime = asset.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+// An http.FileSystem holding a single file that has already been opened.
+type openedFile struct {
+	name string
+	file http.File
+}
+
+func (f openedFile) Open(name string) (http.File, error) {
+	if name != f.name {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return f.file, nil
+}
+
 type CachedFile struct {
 	info   *CachedFileInfo
-	buffer *bytes.Buffer
+	reader *bytes.Reader
+
+	// The digest of the asset, and whether it was requested by its fingerprinted
+	// path.
+	digest        string
+	fingerprinted bool
 }
 
 func (cf CachedFile) Close() error {
@@ -62,9 +265,9 @@ func (cf CachedFile) Readdir(count int) ([]os.FileInfo, error) {
 }
 
 func (cf CachedFile) Read(p []byte) (int, error) {
-	return cf.buffer.Read(p)
+	return cf.reader.Read(p)
 }
 
 func (cf CachedFile) Seek(offset int64, whence int) (int64, error) {
-	return 0, nil
+	return cf.reader.Seek(offset, whence)
 }
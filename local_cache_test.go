@@ -0,0 +1,233 @@
+package monk
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalCacheFingerprints(t *testing.T) {
+	context := newAssetsContext()
+	context.Config.Fingerprint = true
+	cache := &LocalCache{Context: context}
+
+	fingerprint, err := context.digest("d.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(name string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		cache.ServeHTTP(w, httptest.NewRequest("GET", name, nil))
+		return w
+	}
+
+	for _, name := range []string{"/d.js", "/d-" + fingerprint + ".js"} {
+		w := get(name)
+		if w.Code != http.StatusOK || w.Body.String() != "/* d.js */\nafafaf\n\n" {
+			t.Errorf("expected %q to be served, got: %d %q", name, w.Code, w.Body.String())
+		}
+		if contentType := w.Header().Get("Content-Type"); !strings.Contains(contentType, "javascript") {
+			t.Errorf("expected %q to be served as JavaScript, got: %q", name, contentType)
+		}
+	}
+
+	stale := "/d-" + strings.Repeat("0", len(fingerprint)) + ".js"
+	for _, name := range []string{stale, "/missing.js", "/missing-" + fingerprint + ".js"} {
+		if w := get(name); w.Code != http.StatusNotFound {
+			t.Errorf("expected %q to not be found, got: %d %q", name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSplitDigestedPath(t *testing.T) {
+	tests := []struct {
+		digestPath  string
+		logicalPath string
+		fingerprint string
+		ok          bool
+	}{
+		{"app-0123456789abcdef0123456789abcdef.js", "app.js", "0123456789abcdef0123456789abcdef", true},
+		{"nested/jquery-ui-0123456789abcdef0123456789abcdef.min.js", "", "", false},
+		{"nested/jquery.min-0123456789abcdef0123456789abcdef.js", "nested/jquery.min.js", "0123456789abcdef0123456789abcdef", true},
+		{"jquery-ui.js", "", "", false},
+		{"app-0123456789ABCDEF0123456789ABCDEF.js", "", "", false},
+	}
+
+	for _, test := range tests {
+		logicalPath, fingerprint, ok := splitDigestedPath(test.digestPath, NewConfig().Digest)
+		if logicalPath != test.logicalPath || fingerprint != test.fingerprint || ok != test.ok {
+			t.Errorf("splitDigestedPath(%q) = %q, %q, %v, want %q, %q, %v", test.digestPath,
+				logicalPath, fingerprint, ok, test.logicalPath, test.fingerprint, test.ok)
+		}
+	}
+}
+
+func TestLocalCacheHeaders(t *testing.T) {
+	context := newAssetsContext()
+	cache := &LocalCache{Context: context}
+
+	fingerprint, err := context.digest("d.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(context.SearchPaths[0], "d.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(name string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", name, nil)
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		cache.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/d.js", nil)
+	etag := `"` + fingerprint + `"`
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != etag {
+		t.Errorf("expected an ETag of %q, got: %d %q", etag, w.Code, w.Header().Get("ETag"))
+	}
+	if lastModified := w.Header().Get("Last-Modified"); lastModified != info.ModTime().UTC().Format(http.TimeFormat) {
+		t.Errorf("expected Last-Modified to be the modification time of d.js, got: %q", lastModified)
+	}
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "no-cache" {
+		t.Errorf("expected requests without a fingerprint to be revalidated, got: %q", cacheControl)
+	}
+
+	if w := serve("/d.js", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected a matching If-None-Match to return 304, got: %d %q", w.Code, w.Body.String())
+	}
+	if w := serve("/d.js", http.Header{"If-None-Match": {`"stale"`}}); w.Code != http.StatusOK {
+		t.Errorf("expected a stale If-None-Match to return 200, got: %d", w.Code)
+	}
+
+	w = serve("/d-"+fingerprint+".js", nil)
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "public, max-age=31536000, immutable" {
+		t.Errorf("expected fingerprinted requests to be cached forever, got: %q", cacheControl)
+	}
+}
+
+func TestLocalCacheSourceMaps(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js", "app();\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+	cache := &LocalCache{Context: context}
+
+	get := func(name string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		cache.ServeHTTP(w, httptest.NewRequest("GET", name, nil))
+		return w
+	}
+
+	if w := get("/app.js.map"); w.Code != http.StatusNotFound {
+		t.Errorf("expected no source map without Config.SourceMaps, got: %d %q", w.Code, w.Body.String())
+	}
+
+	context.Config.SourceMaps = true
+	w := get("/app.js")
+	if !strings.HasSuffix(w.Body.String(), "//# sourceMappingURL=app.js.map\n") {
+		t.Fatalf("expected app.js to refer to its source map, got: %q", w.Body.String())
+	}
+
+	w = get("/app.js.map")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected the source map to be served as JSON, got: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	sm, err := parseSourceMap(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.File != "app.js" || !eq(sm.Sources, []string{"app.js"}) {
+		t.Errorf("expected the source map of app.js, got: %+v", sm)
+	}
+
+	if w := get("/missing.js.map"); w.Code != http.StatusNotFound {
+		t.Errorf("expected the source map of a missing asset to not be found, got: %d", w.Code)
+	}
+}
+
+func TestLocalCacheIntegrity(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js", "app();\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+	context.Config.SourceMaps = true
+	cache := &LocalCache{Context: context}
+
+	for _, fingerprint := range []bool{false, true} {
+		context.Config.Fingerprint = fingerprint
+		context.Store = map[string]*Asset{}
+
+		url, err := TemplateFilter{}.Process(context, `{{url "app.js"}}`, "html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := context.Integrity("app.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := strings.TrimPrefix(url, "/assets")
+		w := httptest.NewRecorder()
+		cache.ServeHTTP(w, httptest.NewRequest("GET", name, nil))
+		if served := integrity(w.Body.Bytes()); w.Code != http.StatusOK || served != expected {
+			t.Errorf("expected %q to be served with integrity %q, got: %d %q", url, expected, w.Code, served)
+		}
+
+		sourceMapURL := "sourceMappingURL=" + path.Base(name) + ".map"
+		if !strings.Contains(w.Body.String(), sourceMapURL) {
+			t.Errorf("expected %q to refer to its source map as %q, got: %q", url, sourceMapURL, w.Body.String())
+		}
+		w = httptest.NewRecorder()
+		cache.ServeHTTP(w, httptest.NewRequest("GET", name+".map", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("expected the source map of %q to be served, got: %d", url, w.Code)
+		}
+	}
+}
+
+func TestCachedFileRanges(t *testing.T) {
+	content := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00}
+	open := func(name string) CachedFile {
+		info := &CachedFileInfo{name: name, size: int64(len(content)), mode: 0777}
+		return CachedFile{info: info, reader: bytes.NewReader(content)}
+	}
+
+	// Content sniffing reads the start of files without a known extension, then
+	// seeks back to serve them in full.
+	w := httptest.NewRecorder()
+	http.ServeContent(w, httptest.NewRequest("GET", "/image", nil), "image", time.Time{}, open("image"))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) || w.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("expected the whole image, got: %d %q %q", w.Code, w.Header().Get("Content-Type"), w.Body.Bytes())
+	}
+
+	r := httptest.NewRequest("GET", "/fake.jpg", nil)
+	r.Header.Set("Range", "bytes=6-9")
+	w = httptest.NewRecorder()
+	http.ServeContent(w, r, "fake.jpg", time.Time{}, open("fake.jpg"))
+	if w.Code != http.StatusPartialContent || w.Body.String() != "JFIF" {
+		t.Errorf("expected bytes 6-9 of the image, got: %d %q", w.Code, w.Body.Bytes())
+	}
+
+	// Ranges are also supported for built assets served by LocalCache.
+	r = httptest.NewRequest("GET", "/d.js", nil)
+	r.Header.Set("Range", "bytes=11-16")
+	w = httptest.NewRecorder()
+	(&LocalCache{}).ServeHTTP(w, r)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "afafaf" {
+		t.Errorf("expected bytes 11-16 of d.js, got: %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,400 @@
+package monk
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The names that Sprockets gives manifest files, which LoadManifest looks for.
+const manifestPattern = ".sprockets-manifest-*.json"
+
+// A Manifest records the precompiled files in an output directory. It is stored as
+// JSON using the same name and layout as Sprockets' .sprockets-manifest-*.json
+// files, so tools written for Sprockets can read it.
+type Manifest struct {
+	// Details of each precompiled file, keyed by its digested path.
+	Files map[string]*ManifestFile `json:"files"`
+
+	// The digested path of the current version of each asset, keyed by logical path.
+	Assets map[string]string `json:"assets"`
+
+	dir  string
+	name string
+}
+
+// A ManifestFile describes a single precompiled file. Like Sprockets, MTime records
+// the latest modification time of the files it was built from.
+type ManifestFile struct {
+	LogicalPath string    `json:"logical_path"`
+	MTime       time.Time `json:"mtime"`
+	Size        int64     `json:"size"`
+	Digest      string    `json:"digest"`
+
+	// The Subresource Integrity value of the file, such as sha384-<base64 digest>.
+	Integrity string `json:"integrity"`
+
+	// When the file was compiled, which Clean uses to decide what to keep.
+	CompiledAt time.Time `json:"compiled_at"`
+}
+
+// Load the manifest named name in the output directory dir. An empty manifest is
+// returned if it does not exist yet.
+//
+// If name is empty, the manifest is found like Sprockets does: an existing
+// .sprockets-manifest-*.json file in dir is used, and otherwise one is named with a
+// random hex string in place of the *.
+func LoadManifest(dir string, name string) (*Manifest, error) {
+	if name == "" {
+		var err error
+		if name, err = manifestName(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &Manifest{
+		Files:  map[string]*ManifestFile{},
+		Assets: map[string]string{},
+		dir:    dir,
+		name:   name,
+	}
+
+	data, err := ioutil.ReadFile(m.Path())
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Returns the name of the Sprockets manifest in dir, or a new random one if there is
+// none.
+func manifestName(dir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, manifestPattern))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) > 0 {
+		return filepath.Base(matches[0]), nil
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return strings.Replace(manifestPattern, "*", fmt.Sprintf("%x", random), 1), nil
+}
+
+// Return the path to the manifest file.
+func (m *Manifest) Path() string {
+	return filepath.Join(m.dir, m.name)
+}
+
+// Write the manifest to disk.
+func (m *Manifest) Save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(m.Path(), data, 0644)
+}
+
+// Build each asset and write it to the output directory with its digest in its
+// file name, recording it in the manifest. Assets linked to by the compiled assets
+// are compiled as well. Entries in logicalPaths may be globs, such as *.js, which
+// are matched against every asset in the context's search paths.
+//
+// Compiled files are only written under their digested paths, so URLs in them are
+// always fingerprinted, whether or not context's Config enables it.
+//
+// The manifest is not saved; call Save once compilation has finished.
+func (m *Manifest) Compile(context *Context, logicalPaths ...string) error {
+	if !context.Config.Fingerprint {
+		context = fingerprintingContext(context)
+	}
+
+	queue, err := expandGlobs(context, logicalPaths)
+	if err != nil {
+		return err
+	}
+
+	compiled := []string{}
+	for len(queue) > 0 {
+		logicalPath := queue[0]
+		queue = queue[1:]
+		if contains(logicalPath, compiled) {
+			continue
+		}
+		compiled = append(compiled, logicalPath)
+
+		r := &Resolution{}
+		if err := r.Resolve(logicalPath, context); err != nil {
+			return err
+		}
+		if err := m.write(context, r); err != nil {
+			return err
+		}
+
+		for _, resolved := range r.Resolved {
+			asset, err := context.lookup(resolved)
+			if err != nil {
+				return err
+			}
+			queue = append(queue, asset.Links...)
+		}
+	}
+
+	return nil
+}
+
+// Returns a new Context that finds the same assets as context, with the same
+// configuration except that URLs are fingerprinted.
+func fingerprintingContext(context *Context) *Context {
+	config := *context.Config
+	config.Fingerprint = true
+
+	c := NewContext(context.fs)
+	c.SearchPaths = append([]string{}, context.SearchPaths...)
+	c.Config = &config
+	return c
+}
+
+// Build r and write it, and its source map if enabled, to the output directory.
+func (m *Manifest) write(context *Context, r *Resolution) error {
+	compiled, err := compile(r, context)
+	if err != nil {
+		return err
+	}
+
+	absPath := filepath.Join(m.dir, filepath.FromSlash(compiled.digestPath))
+	if compiled.sourceMap != nil {
+		if err := writeFile(absPath+".map", compiled.sourceMap); err != nil {
+			return err
+		}
+	}
+	if err := writeFile(absPath, compiled.content); err != nil {
+		return err
+	}
+
+	mtime, err := lastModified(r, context)
+	if err != nil {
+		return err
+	}
+
+	m.Files[compiled.digestPath] = &ManifestFile{
+		LogicalPath: r.root,
+		MTime:       mtime,
+		Size:        int64(len(compiled.content)),
+		Digest:      compiled.digest,
+		Integrity:   integrity(compiled.content),
+		CompiledAt:  time.Now(),
+	}
+	m.Assets[r.root] = compiled.digestPath
+	return nil
+}
+
+// Return the Subresource Integrity value of the current version of the asset at
+// logicalPath, or an empty string if it has not been compiled.
+func (m *Manifest) Integrity(logicalPath string) string {
+	if file, ok := m.Files[m.Assets[logicalPath]]; ok {
+		return file.Integrity
+	}
+	return ""
+}
+
+// A bundle as it is written to the output directory by Compile.
+type compiledAsset struct {
+	digestPath string
+	digest     string
+	content    []byte
+
+	// The JSON source map of the bundle, or nil if source maps are disabled.
+	sourceMap []byte
+}
+
+// Build r as it is written to the output directory by Compile.
+func compile(r *Resolution, context *Context) (*compiledAsset, error) {
+	var out bytes.Buffer
+	if err := buildTo(&out, r, context, ""); err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := BundleDigest(r, context)
+	if err != nil {
+		return nil, err
+	}
+	compiled := &compiledAsset{
+		digestPath: digestedPath(r.root, fingerprint),
+		digest:     fingerprint,
+		content:    out.Bytes(),
+	}
+
+	// The source map comment refers to the digested file name, so it is added once
+	// the digest is known.
+	if context.Config.SourceMaps {
+		sm, err := BuildSourceMap(r, context)
+		if err != nil {
+			return nil, err
+		}
+		sm.File = path.Base(compiled.digestPath)
+		if compiled.sourceMap, err = json.Marshal(sm); err != nil {
+			return nil, err
+		}
+
+		var comment bytes.Buffer
+		if err := writeSourceMappingURL(&comment, r, path.Base(compiled.digestPath)+".map"); err != nil {
+			return nil, err
+		}
+		compiled.content = append(compiled.content, comment.Bytes()...)
+	}
+
+	return compiled, nil
+}
+
+// Remove old versions of each asset from the output directory and the manifest,
+// then save the manifest. The current version of every asset is always kept, along
+// with its keep most recent previous versions and any version compiled less than
+// age ago.
+func (m *Manifest) Clean(keep int, age time.Duration) error {
+	backups := map[string][]string{}
+	for digestPath, file := range m.Files {
+		if m.Assets[file.LogicalPath] != digestPath {
+			backups[file.LogicalPath] = append(backups[file.LogicalPath], digestPath)
+		}
+	}
+
+	now := time.Now()
+	for _, digestPaths := range backups {
+		sort.Sort(byCompiledAt{digestPaths, m.Files})
+
+		for i, digestPath := range digestPaths {
+			if i < keep || now.Sub(m.Files[digestPath].CompiledAt) < age {
+				continue
+			}
+			if err := m.remove(digestPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return m.Save()
+}
+
+// Remove a precompiled file, along with its source map, from the output directory
+// and the manifest.
+func (m *Manifest) remove(digestPath string) error {
+	absPath := filepath.Join(m.dir, filepath.FromSlash(digestPath))
+	for _, name := range []string{absPath, absPath + ".map"} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(m.Files, digestPath)
+	return nil
+}
+
+// Remove the output directory, including the manifest, entirely.
+func (m *Manifest) Clobber() error {
+	if err := os.RemoveAll(m.dir); err != nil {
+		return err
+	}
+	m.Files = map[string]*ManifestFile{}
+	m.Assets = map[string]string{}
+	return nil
+}
+
+// Sorts digested paths from the most to the least recently compiled.
+type byCompiledAt struct {
+	paths []string
+	files map[string]*ManifestFile
+}
+
+func (s byCompiledAt) Len() int { return len(s.paths) }
+func (s byCompiledAt) Less(i, j int) bool {
+	return s.files[s.paths[i]].CompiledAt.After(s.files[s.paths[j]].CompiledAt)
+}
+func (s byCompiledAt) Swap(i, j int) { s.paths[i], s.paths[j] = s.paths[j], s.paths[i] }
+
+// Inserts fingerprint into logicalPath before its extension. foo/bar.js becomes
+// foo/bar-<fingerprint>.js.
+func digestedPath(logicalPath string, fingerprint string) string {
+	ext := path.Ext(logicalPath)
+	return strings.TrimSuffix(logicalPath, ext) + "-" + fingerprint + ext
+}
+
+// Splits a fingerprinted path such as foo/bar-<fingerprint>.js into foo/bar.js and
+// its fingerprint. Returns false unless the fingerprint is hex encoded and as long as
+// those produced by algorithm.
+func splitDigestedPath(digestPath string, algorithm crypto.Hash) (string, string, bool) {
+	if !algorithm.Available() {
+		return "", "", false
+	}
+
+	ext := path.Ext(digestPath)
+	base := strings.TrimSuffix(digestPath, ext)
+	i := strings.LastIndex(base, "-")
+	if i < 0 {
+		return "", "", false
+	}
+
+	fingerprint := base[i+1:]
+	if len(fingerprint) != algorithm.Size()*2 || strings.Trim(fingerprint, "0123456789abcdef") != "" {
+		return "", "", false
+	}
+	return base[:i] + ext, fingerprint, true
+}
+
+// Replaces any globs in logicalPaths with the logical paths of the assets that
+// match them.
+func expandGlobs(context *Context, logicalPaths []string) ([]string, error) {
+	result := []string{}
+	var all []string
+	for _, pattern := range logicalPaths {
+		if !strings.ContainsAny(pattern, "*?[") {
+			result = append(result, pattern)
+			continue
+		}
+
+		if all == nil {
+			var err error
+			if all, err = context.LogicalPaths(); err != nil {
+				return nil, err
+			}
+		}
+		for _, logicalPath := range all {
+			matched, err := path.Match(pattern, logicalPath)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				result = append(result, logicalPath)
+			}
+		}
+	}
+	return result, nil
+}
+
+func writeFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, data, 0644)
+}
@@ -0,0 +1,235 @@
+package monk
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManifestCompile(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js.tmpl", "//= require lib\n"+`var logo = '{{url "logo.png"}}';`+"\n")
+	fs.File("assets/lib.js", "lib();\n")
+	fs.File("assets/logo.png", "not really a png")
+	fs.File("assets/a.css", "a {}\n")
+	fs.File("assets/nested/b.css", "b {}\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+
+	dir, err := ioutil.TempDir("", "monk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := LoadManifest(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manifest.Compile(context, "app.js", "*.css"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// URLs in compiled files refer to the other compiled files, even though the
+	// context doesn't fingerprint them.
+	contents := map[string]string{
+		"app.js":   "/* lib.js */\nlib();\n\n/* app.js */\nvar logo = '/assets/" + manifest.Assets["logo.png"] + "';\n\n",
+		"logo.png": "not really a png",
+		"a.css":    "/* a.css */\na {}\n\n",
+	}
+	if len(manifest.Assets) != len(contents) {
+		t.Errorf("expected assets %v, got: %v", contents, manifest.Assets)
+	}
+
+	sources := map[string][]string{
+		"app.js":   {"assets/app.js.tmpl", "assets/lib.js"},
+		"logo.png": {"assets/logo.png"},
+		"a.css":    {"assets/a.css"},
+	}
+
+	fingerprinting := fingerprintingContext(context)
+	expected := map[string]string{}
+	for logicalPath, expectedContent := range contents {
+		fingerprint, err := fingerprinting.digest(logicalPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digestPath := digestedPath(logicalPath, fingerprint)
+		expected[logicalPath] = digestPath
+
+		if manifest.Assets[logicalPath] != digestPath {
+			t.Errorf("expected %q to be compiled to %q, got: %q", logicalPath, digestPath, manifest.Assets[logicalPath])
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, digestPath))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(content) != expectedContent {
+			t.Errorf("expected %q to contain %q, got: %q", digestPath, expectedContent, content)
+		}
+		if manifest.Integrity(logicalPath) != integrity(content) {
+			t.Errorf("expected the integrity of %q to be %q, got: %q", logicalPath, integrity(content), manifest.Integrity(logicalPath))
+		}
+
+		file := manifest.Files[digestPath]
+		if file.LogicalPath != logicalPath || file.Size != int64(len(content)) || digestPath != digestedPath(logicalPath, file.Digest) {
+			t.Errorf("unexpected manifest entry for %q: %+v", digestPath, file)
+		}
+		var mtime time.Time
+		for _, source := range sources[logicalPath] {
+			if info, _ := fs.Stat(source); info.ModTime().After(mtime) {
+				mtime = info.ModTime()
+			}
+		}
+		if !file.MTime.Equal(mtime) {
+			t.Errorf("expected the mtime of %q to be that of its latest source, %v, got: %v", digestPath, mtime, file.MTime)
+		}
+		if file.CompiledAt.IsZero() {
+			t.Errorf("expected the compile time of %q to be recorded", digestPath)
+		}
+	}
+
+	// The manifest on disk uses the Sprockets name and layout.
+	if matched, _ := filepath.Match(".sprockets-manifest-????????????????????????????????.json", filepath.Base(manifest.Path())); !matched {
+		t.Errorf("expected a Sprockets manifest name, got: %q", manifest.Path())
+	}
+	data, err := ioutil.ReadFile(manifest.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct {
+		Files  map[string]map[string]interface{} `json:"files"`
+		Assets map[string]string                 `json:"assets"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"logical_path", "mtime", "size", "digest", "integrity", "compiled_at"} {
+		if _, ok := raw.Files[expected["a.css"]][key]; !ok {
+			t.Errorf("expected manifest files to include %q, got: %v", key, raw.Files[expected["a.css"]])
+		}
+	}
+
+	reloaded, err := LoadManifest(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Path() != manifest.Path() || reloaded.Assets["app.js"] != expected["app.js"] {
+		t.Errorf("expected the saved manifest to be loaded, got: %v", reloaded.Assets)
+	}
+}
+
+func TestManifestSourceMaps(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js", "app();\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+	context.Config.SourceMaps = true
+
+	dir, err := ioutil.TempDir("", "monk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := LoadManifest(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Compile(context, "app.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	digestPath := manifest.Assets["app.js"]
+	content, err := ioutil.ReadFile(filepath.Join(dir, digestPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "/* app.js */\napp();\n\n//# sourceMappingURL=" + digestPath + ".map\n"
+	if string(content) != expected {
+		t.Errorf("expected %q, got: %q", expected, content)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, digestPath+".map")); err != nil {
+		t.Errorf("expected a source map to be written: %s", err)
+	}
+}
+
+func TestManifestClean(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := LoadManifest(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	versions := []struct {
+		digestPath string
+		age        time.Duration
+	}{
+		{"app-1.js", 5 * time.Hour},
+		{"app-2.js", 4 * time.Hour},
+		{"app-3.js", 3 * time.Hour},
+		{"app-4.js", 30 * time.Minute},
+		{"app-5.js", 20 * time.Minute},
+		{"app-6.js", 5 * time.Minute},
+	}
+	for _, v := range versions {
+		if err := writeFile(filepath.Join(dir, v.digestPath), []byte(v.digestPath)); err != nil {
+			t.Fatal(err)
+		}
+		manifest.Files[v.digestPath] = &ManifestFile{LogicalPath: "app.js", CompiledAt: now.Add(-v.age)}
+	}
+	writeFile(filepath.Join(dir, "app-1.js.map"), []byte("{}"))
+
+	// The current version is kept even though it is the oldest.
+	manifest.Assets["app.js"] = "app-1.js"
+
+	if err := manifest.Clean(2, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := map[string]bool{"app-1.js": true, "app-5.js": true, "app-6.js": true, "app-4.js": true, "app-3.js": false, "app-2.js": false}
+	for digestPath, shouldExist := range kept {
+		_, err := os.Stat(filepath.Join(dir, digestPath))
+		if exists := err == nil; exists != shouldExist {
+			t.Errorf("expected %q to exist: %v, got: %v", digestPath, shouldExist, exists)
+		}
+		if _, ok := manifest.Files[digestPath]; ok != shouldExist {
+			t.Errorf("expected %q to be in the manifest: %v, got: %v", digestPath, shouldExist, ok)
+		}
+	}
+
+	reloaded, err := LoadManifest(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Files) != 4 {
+		t.Errorf("expected the cleaned manifest to be saved, got: %v", reloaded.Files)
+	}
+
+	if err := manifest.Clobber(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected %q to be removed, got: %v", dir, err)
+	}
+}
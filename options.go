@@ -0,0 +1,122 @@
+package monk
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Options holds the settings shared by monk's command line tools, which read them
+// from flags and use them to create a Context.
+type Options struct {
+	// Directories to search for assets. Relative paths are made absolute when
+	// they are set from a flag.
+	SearchPaths []string
+
+	AssetRoot string
+
+	// The name of the hash function used to fingerprint assets, as accepted by
+	// ParseDigest.
+	Digest string
+
+	// The environment whose defaults are used, either development or production.
+	Environment string
+
+	// Whether URLs are fingerprinted. The environment's default is used if nil.
+	Fingerprint *bool
+
+	// Whether source maps are generated for built bundles.
+	SourceMaps bool
+}
+
+// The configuration that differs between environments.
+var environments = map[string]func(*Config){
+	"development": func(c *Config) {},
+	"production":  func(c *Config) { c.Fingerprint = true },
+}
+
+// Registers flags for each option on fs, using the defaults of a new Config.
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	config := NewConfig()
+	fs.Var((*searchPathsValue)(&o.SearchPaths), "s", "path to search for assets when building")
+	fs.StringVar(&o.AssetRoot, "r", config.AssetRoot, "asset root used in compiled files")
+	fs.StringVar(&o.Digest, "digest", "md5", "hash function used to fingerprint assets: md5, sha256, sha384 or sha512")
+	fs.StringVar(&o.Environment, "env", "development", "environment to use the defaults of: development or production")
+	fs.Var(optionalBool{&o.Fingerprint}, "fingerprint", "fingerprint asset URLs (default true in production)")
+	fs.BoolVar(&o.SourceMaps, "sourcemaps", config.SourceMaps, "generate source maps for built bundles")
+}
+
+// Create a Context that reads assets from fs, configured by the options.
+func (o *Options) NewContext(fs fileSystem) (*Context, error) {
+	if len(o.SearchPaths) == 0 {
+		return nil, errors.New("You must specify at least one path using -s")
+	}
+
+	configure, ok := environments[o.Environment]
+	if !ok {
+		return nil, fmt.Errorf("unknown environment %q", o.Environment)
+	}
+
+	digest, err := ParseDigest(o.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	context := NewContext(fs)
+	configure(context.Config)
+	context.Config.AssetRoot = o.AssetRoot
+	context.Config.Digest = digest
+	context.Config.SourceMaps = o.SourceMaps
+	if o.Fingerprint != nil {
+		context.Config.Fingerprint = *o.Fingerprint
+	}
+
+	for _, searchPath := range o.SearchPaths {
+		context.SearchPath(searchPath)
+	}
+	return context, nil
+}
+
+// A flag.Value that appends each search path it is given, made absolute.
+type searchPathsValue []string
+
+func (sp *searchPathsValue) Set(searchPath string) error {
+	searchPath, err := filepath.Abs(searchPath)
+	if err != nil {
+		return err
+	}
+	*sp = append(*sp, searchPath)
+	return nil
+}
+
+func (sp *searchPathsValue) String() string {
+	return strings.Join(*sp, ",")
+}
+
+// A boolean flag.Value that leaves its target nil unless the flag is given.
+type optionalBool struct {
+	value **bool
+}
+
+func (b optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b.value = &v
+	return nil
+}
+
+func (b optionalBool) String() string {
+	if b.value == nil || *b.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(**b.value)
+}
+
+func (b optionalBool) IsBoolFlag() bool {
+	return true
+}
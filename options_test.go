@@ -0,0 +1,71 @@
+package monk
+
+import (
+	"crypto"
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	parse := func(args ...string) (*Context, error) {
+		var options Options
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		options.AddFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return options.NewContext(NewTestFS())
+	}
+
+	if _, err := parse(); err == nil {
+		t.Errorf("expected an error without any search paths")
+	}
+	if _, err := parse("-s", "assets", "-env", "staging"); err == nil {
+		t.Errorf("expected an error for an unknown environment")
+	}
+
+	context, err := parse("-s", "assets", "-s", "/vendor/assets", "-r", "/a/", "-digest", "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, _ := filepath.Abs("assets")
+	if !eq(context.SearchPaths, []string{abs, "/vendor/assets"}) {
+		t.Errorf("expected absolute search paths, got: %q", context.SearchPaths)
+	}
+	if context.Config.AssetRoot != "/a/" || context.Config.Digest != crypto.SHA256 || context.Config.Fingerprint {
+		t.Errorf("unexpected development config: %+v", context.Config)
+	}
+
+	context, err = parse("-s", "assets", "-env", "production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !context.Config.Fingerprint || context.Config.AssetRoot != "/assets/" {
+		t.Errorf("expected production to fingerprint URLs, got: %+v", context.Config)
+	}
+
+	context, err = parse("-s", "assets", "-env", "production", "-fingerprint=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if context.Config.Fingerprint {
+		t.Errorf("expected -fingerprint=false to override the environment")
+	}
+
+	context, err = parse("-s", "assets", "-fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !context.Config.Fingerprint {
+		t.Errorf("expected -fingerprint to enable fingerprinting")
+	}
+
+	context, err = parse("-s", "assets", "-sourcemaps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !context.Config.SourceMaps {
+		t.Errorf("expected -sourcemaps to enable source maps")
+	}
+}
@@ -1,16 +1,36 @@
 package monk
 
 import (
+	"errors"
 	"fmt"
 )
 
 type Resolution struct {
 	Resolved []string
 	Seen     []string
+	Stubbed  []string
+
+	// The chain of requires leading to the asset currently being resolved.
+	stack []Require
+
+	// The logical path of the first asset resolved, which names the bundle.
+	root string
+}
+
+// A Require is a single edge in the dependency graph: the directive on Line of the
+// file at Path, which belongs to the asset From, requires the asset To.
+type Require struct {
+	From string
+	To   string
+	Path string
+	Line int
 }
 
 // Resolve the asset at assetPath and its dependencies.
 func (r *Resolution) Resolve(assetPath string, context *Context) error {
+	if r.root == "" {
+		r.root = assetPath
+	}
 	r.Seen = append(r.Seen, assetPath)
 
 	asset, err := context.lookup(assetPath)
@@ -18,18 +38,94 @@ func (r *Resolution) Resolve(assetPath string, context *Context) error {
 		return err
 	}
 
-	for _, edge := range asset.Dependencies {
+	for _, stub := range asset.Stubs {
+		if err := r.stub(stub, context); err != nil {
+			return fmt.Errorf("failed to stub %q: %w", stub, err)
+		}
+	}
+
+	for i, edge := range asset.Dependencies {
+		if i == asset.selfIndex {
+			r.Resolved = append(r.Resolved, assetPath)
+		}
+		if contains(edge, r.Stubbed) {
+			continue
+		}
+		// With require_self, an asset is resolved before its later dependencies,
+		// so requires that lead back to it are checked against the assets still
+		// being resolved rather than those already resolved.
+		req := Require{assetPath, edge, asset.Path, asset.dependencyLines[i]}
+		if r.resolving(req) {
+			return &CycleError{r.cycle(req)}
+		}
 		if !contains(edge, r.Resolved) {
-			if contains(edge, r.Seen) {
-				return fmt.Errorf("circular dependency detected: %s <-> %s", assetPath, edge)
+			r.stack = append(r.stack, req)
+			err := r.Resolve(edge, context)
+			r.stack = r.stack[:len(r.stack)-1]
+
+			var cycle *CycleError
+			if errors.As(err, &cycle) {
+				return err
 			}
-			if err := r.Resolve(edge, context); err != nil {
-				return fmt.Errorf("failed to resolve %q:, %s", edge, err.Error())
+			if err != nil {
+				return fmt.Errorf("failed to resolve %q: %w", edge, err)
 			}
 		}
 	}
 
-	r.Resolved = append(r.Resolved, assetPath)
+	if asset.selfIndex >= len(asset.Dependencies) {
+		r.Resolved = append(r.Resolved, assetPath)
+	}
+	return nil
+}
+
+// Returns true if req leads back to an asset that is still being resolved.
+func (r *Resolution) resolving(req Require) bool {
+	if req.To == req.From {
+		return true
+	}
+	for _, prev := range r.stack {
+		if prev.From == req.To {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the chain of requires that leads from req.To back to itself, ending
+// with req.
+func (r *Resolution) cycle(req Require) []Require {
+	for i, prev := range r.stack {
+		if prev.From == req.To {
+			chain := append([]Require{}, r.stack[i:]...)
+			return append(chain, req)
+		}
+	}
+	return []Require{req}
+}
+
+// Excludes the asset at assetPath and its entire dependency tree from r, removing
+// any of them that have already been resolved.
+func (r *Resolution) stub(assetPath string, context *Context) error {
+	stubbed := &Resolution{}
+	if err := stubbed.Resolve(assetPath, context); err != nil {
+		return err
+	}
+
+	for _, logicalPath := range stubbed.Resolved {
+		if !contains(logicalPath, r.Stubbed) {
+			r.Stubbed = append(r.Stubbed, logicalPath)
+		}
+	}
+
+	resolved := r.Resolved[:0]
+	for _, logicalPath := range r.Resolved {
+		if !contains(logicalPath, r.Stubbed) {
+			resolved = append(resolved, logicalPath)
+		}
+	}
+	r.Resolved = resolved
+
 	return nil
 }
 
@@ -0,0 +1,84 @@
+package monk
+
+import (
+	"testing"
+)
+
+func TestCycleError(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/a.js", "//= require b\n")
+	fs.File("assets/b.js", "// b\n//= require d\n//= require c\n")
+	fs.File("assets/c.js", "//= require_tree ./lib\n")
+	fs.File("assets/d.js", "")
+	fs.File("assets/lib/e.js", "\n//= require b\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+
+	r := &Resolution{}
+	err := r.Resolve("a.js", context)
+
+	cycle, ok := err.(*CycleError)
+	if !ok {
+		t.Fatalf("expected a *CycleError, got: %v", err)
+	}
+
+	expected := []Require{
+		{"b.js", "c.js", "assets/b.js", 3},
+		{"c.js", "lib/e.js", "assets/c.js", 1},
+		{"lib/e.js", "b.js", "assets/lib/e.js", 2},
+	}
+	if len(cycle.Chain) != len(expected) {
+		t.Fatalf("expected chain %v, got: %v", expected, cycle.Chain)
+	}
+	for i := range expected {
+		if cycle.Chain[i] != expected[i] {
+			t.Errorf("expected chain[%d] to be %v, got: %v", i, expected[i], cycle.Chain[i])
+		}
+	}
+
+	msg := "circular dependency detected: b.js -> c.js -> lib/e.js -> b.js\n" +
+		"  assets/b.js:3: b.js requires c.js\n" +
+		"  assets/c.js:1: c.js requires lib/e.js\n" +
+		"  assets/lib/e.js:2: lib/e.js requires b.js"
+	if err.Error() != msg {
+		t.Errorf("expected %q, got: %q", msg, err.Error())
+	}
+}
+
+func TestSelfRequire(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/a.js", "//= require a\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+
+	r := &Resolution{}
+	err := r.Resolve("a.js", context)
+	if cycle, ok := err.(*CycleError); !ok || len(cycle.Chain) != 1 {
+		t.Errorf("expected a single require cycle, got: %v", err)
+	}
+}
+
+func TestCycleThroughRequireSelf(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/a.js", "//= require_self\n//= require b\n")
+	fs.File("assets/b.js", "//= require a\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+
+	r := &Resolution{}
+	err := r.Resolve("a.js", context)
+	cycle, ok := err.(*CycleError)
+	if !ok {
+		t.Fatalf("expected a *CycleError, got: %v (resolved %q)", err, r.Resolved)
+	}
+
+	msg := "circular dependency detected: a.js -> b.js -> a.js\n" +
+		"  assets/a.js:2: a.js requires b.js\n" +
+		"  assets/b.js:1: b.js requires a.js"
+	if len(cycle.Chain) != 2 || err.Error() != msg {
+		t.Errorf("expected %q, got: %q", msg, err.Error())
+	}
+}
@@ -1,17 +1,80 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/jim/monk"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
+var options monk.Options
+
+var listenFlag string
+
+var liveReloadFlag bool
+
+var pollFlag time.Duration
+
+func init() {
+	options.AddFlags(flag.CommandLine)
+	flag.StringVar(&listenFlag, "listen", ":8080", "address to listen for requests on")
+	flag.BoolVar(&liveReloadFlag, "livereload", true, "reload stylesheets and pages in the browser as assets change, in development")
+	flag.DurationVar(&pollFlag, "poll", 500*time.Millisecond, "how often to check for changes when live reloading")
+}
+
 func main() {
-	cache := &monk.LocalCache{}
-	http.Handle("/assets/", http.StripPrefix("/assets/", logRequest(http.FileServer(cache))))
-	fmt.Println("Starting an asset server on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	flag.Parse()
+
+	context, err := options.NewContext(monk.DiskFS{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	prefix, err := assetPrefix(context.Config.AssetRoot)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cache := &monk.LocalCache{Context: context}
+	http.Handle(prefix, http.StripPrefix(prefix, logRequest(cache)))
+	fmt.Printf("Starting an asset server on %s, serving %s from %s...\n",
+		listenFlag, prefix, strings.Join(context.SearchPaths, ", "))
+
+	if liveReloadFlag && options.Environment == "development" {
+		watcher := monk.NewWatcher(context)
+		watcher.OnError = func(err error) { log.Print(err) }
+		if _, err := watcher.Poll(); err != nil {
+			log.Fatal(err)
+		}
+		watcher.Start(pollFlag)
+
+		// Browsers stay connected to the live reload endpoint, so its requests are
+		// not logged.
+		root := strings.TrimSuffix(context.Config.AssetRoot, "/") + "/"
+		http.Handle(prefix+"livereload", &monk.LiveReload{Watcher: watcher})
+		http.Handle(prefix+"livereload.js", monk.LiveReloadScriptHandler(root+"livereload", context.Config.AssetRoot))
+		fmt.Printf("Include %slivereload.js in pages to reload them as assets change.\n", root)
+	}
+	log.Fatal(http.ListenAndServe(listenFlag, nil))
+}
+
+// Returns the path that assets are served under, given the asset root used in
+// compiled files. The root may be a full URL, such as that of a CDN that proxies
+// requests to the server.
+func assetPrefix(assetRoot string) (string, error) {
+	u, err := url.Parse(assetRoot)
+	if err != nil {
+		return "", err
+	}
+	prefix := "/" + strings.Trim(u.Path, "/") + "/"
+	if prefix == "//" {
+		prefix = "/"
+	}
+	return prefix, nil
 }
 
 type statusResponseWriter struct {
@@ -0,0 +1,257 @@
+package monk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// A SourceMap is a revision 3 source map, as described at
+// https://sourcemaps.info/spec.html.
+type SourceMap struct {
+	Version        int      `json:"version"`
+	File           string   `json:"file,omitempty"`
+	SourceRoot     string   `json:"sourceRoot,omitempty"`
+	Sources        []string `json:"sources"`
+	SourcesContent []string `json:"sourcesContent,omitempty"`
+	Names          []string `json:"names"`
+	Mappings       string   `json:"mappings"`
+}
+
+// SourceMapProcessor is implemented by filters that can describe how their output
+// relates to their input. When Config.SourceMaps is enabled, ProcessWithSourceMap is
+// used instead of Process, and the returned map is composed with the maps of any
+// other filters applied to the same file.
+//
+// Filters that don't implement this interface are assumed to keep each line of
+// their input on the same line of their output.
+type SourceMapProcessor interface {
+	ProcessWithSourceMap(context *Context, content string, extension string) (string, *SourceMap, error)
+}
+
+// A segment maps a column of a generated line back to a line and column of a
+// source. Lines and columns are zero based.
+type segment struct {
+	column    int
+	source    int
+	line      int
+	srcColumn int
+}
+
+// The segments of each generated line, in order.
+type mappings [][]segment
+
+// Returns mappings for content whose lines came from the given zero based lines of
+// a single source.
+func lineMappings(lines []int) mappings {
+	m := make(mappings, len(lines))
+	for i, line := range lines {
+		m[i] = []segment{{0, 0, line, 0}}
+	}
+	return m
+}
+
+// Maps m, which describes how a filter's output relates to its input, through prev,
+// which describes how that input relates to the original source.
+func (m mappings) compose(prev mappings) mappings {
+	result := make(mappings, len(m))
+	for i, line := range m {
+		for _, seg := range line {
+			if seg.line >= len(prev) || len(prev[seg.line]) == 0 {
+				continue
+			}
+
+			// Find the segment of the input that covers the mapped column.
+			from := prev[seg.line][0]
+			for _, p := range prev[seg.line] {
+				if p.column > seg.srcColumn {
+					break
+				}
+				from = p
+			}
+
+			srcColumn := from.srcColumn + seg.srcColumn - from.column
+			if srcColumn < 0 {
+				srcColumn = 0
+			}
+			result[i] = append(result[i], segment{seg.column, from.source, from.line, srcColumn})
+		}
+	}
+	return result
+}
+
+const base64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+func writeVLQ(buf *bytes.Buffer, value int) {
+	vlq := value << 1
+	if value < 0 {
+		vlq = (-value << 1) | 1
+	}
+	for {
+		digit := vlq & 31
+		vlq >>= 5
+		if vlq > 0 {
+			digit |= 32
+		}
+		buf.WriteByte(base64Digits[digit])
+		if vlq == 0 {
+			return
+		}
+	}
+}
+
+func readVLQ(r *strings.Reader) (int, error) {
+	value, shift := 0, uint(0)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("unterminated VLQ value in source map mappings")
+		}
+		digit := strings.IndexByte(base64Digits, c)
+		if digit < 0 {
+			return 0, fmt.Errorf("invalid character %q in source map mappings", c)
+		}
+		value += (digit & 31) << shift
+		shift += 5
+		if digit&32 == 0 {
+			break
+		}
+	}
+
+	if value&1 == 1 {
+		return -(value >> 1), nil
+	}
+	return value >> 1, nil
+}
+
+// Encode m as the mappings field of a source map.
+func (m mappings) encode() string {
+	var buf bytes.Buffer
+	source, line, srcColumn := 0, 0, 0
+	for i, segments := range m {
+		if i > 0 {
+			buf.WriteByte(';')
+		}
+		column := 0
+		for j, seg := range segments {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			writeVLQ(&buf, seg.column-column)
+			writeVLQ(&buf, seg.source-source)
+			writeVLQ(&buf, seg.line-line)
+			writeVLQ(&buf, seg.srcColumn-srcColumn)
+			column, source, line, srcColumn = seg.column, seg.source, seg.line, seg.srcColumn
+		}
+	}
+	return buf.String()
+}
+
+// Decode the mappings field of a source map. Segments without a source are
+// skipped, and names are ignored.
+func decodeMappings(encoded string) (mappings, error) {
+	m := mappings{}
+	source, line, srcColumn, name := 0, 0, 0, 0
+
+	for _, encodedLine := range strings.Split(encoded, ";") {
+		segments := []segment{}
+		column := 0
+		for _, encodedSegment := range strings.Split(encodedLine, ",") {
+			if encodedSegment == "" {
+				continue
+			}
+
+			r := strings.NewReader(encodedSegment)
+			fields := []*int{&column, &source, &line, &srcColumn, &name}
+			n := 0
+			for ; r.Len() > 0; n++ {
+				if n == len(fields) {
+					return nil, fmt.Errorf("too many fields in source map segment %q", encodedSegment)
+				}
+				delta, err := readVLQ(r)
+				if err != nil {
+					return nil, err
+				}
+				*fields[n] += delta
+			}
+
+			if n >= 4 {
+				segments = append(segments, segment{column, source, line, srcColumn})
+			}
+		}
+		m = append(m, segments)
+	}
+
+	return m, nil
+}
+
+// BuildSourceMap returns a source map for the bundle that BuildTo writes for r,
+// mapping each line of every asset back to the file and line it came from.
+func BuildSourceMap(r *Resolution, context *Context) (*SourceMap, error) {
+	sm := &SourceMap{
+		Version:        3,
+		File:           path.Base(r.root),
+		Sources:        []string{},
+		SourcesContent: []string{},
+		Names:          []string{},
+	}
+
+	m := mappings{}
+	for i, logicalPath := range r.Resolved {
+		asset, err := context.lookup(logicalPath)
+		if err != nil {
+			return nil, err
+		}
+
+		source := asset.Path
+		if searchPath, ok := context.searchPathFor(asset.Path); ok {
+			source = strings.TrimPrefix(asset.Path, searchPath+"/")
+		}
+		sm.Sources = append(sm.Sources, source)
+		sm.SourcesContent = append(sm.SourcesContent, asset.source)
+
+		// The header comment written before each asset has no source.
+		m = append(m, []segment{})
+
+		lines := strings.Count(asset.Content, "\n") + 1
+		for j := 0; j < lines; j++ {
+			segments := []segment{}
+			if j < len(asset.mappings) {
+				for _, seg := range asset.mappings[j] {
+					seg.source = i
+					segments = append(segments, seg)
+				}
+			}
+			m = append(m, segments)
+		}
+	}
+
+	sm.Mappings = m.encode()
+	return sm, nil
+}
+
+// Writes the comment that tells browsers where to find the source map for a bundle
+// built from r.
+func writeSourceMappingURL(w io.Writer, r *Resolution, url string) error {
+	format := "//# sourceMappingURL=%s\n"
+	if _, ext, _ := splitExtensions(r.root); ext == "css" || ext == "less" {
+		format = "/*# sourceMappingURL=%s */\n"
+	}
+	_, err := fmt.Fprintf(w, format, url)
+	return err
+}
+
+// Parses a source map from its JSON representation.
+func parseSourceMap(data []byte) (*SourceMap, error) {
+	sm := &SourceMap{}
+	if err := json.Unmarshal(data, sm); err != nil {
+		return nil, err
+	}
+	if sm.Version != 3 {
+		return nil, fmt.Errorf("unsupported source map version %d", sm.Version)
+	}
+	return sm, nil
+}
@@ -0,0 +1,112 @@
+package monk
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMappingsRoundTrip(t *testing.T) {
+	encoded := "AAAA,IAAI;;AACA,gBAAgB;AAClB,aAAc"
+	m, err := decodeMappings(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 4 || len(m[0]) != 2 || len(m[1]) != 0 {
+		t.Fatalf("decodeMappings(%q) = %v", encoded, m)
+	}
+	if expected := (segment{16, 0, 1, 20}); m[2][1] != expected {
+		t.Errorf("decodeMappings(%q)[2][1] = %v, want %v", encoded, m[2][1], expected)
+	}
+	if reencoded := m.encode(); reencoded != encoded {
+		t.Errorf("encode() = %q, want %q", reencoded, encoded)
+	}
+
+	if _, err := decodeMappings("AA!A"); err == nil {
+		t.Error("expected invalid mappings to fail to decode")
+	}
+}
+
+// A filter that adds a line to the top of its input, and reports this in a
+// source map.
+type bannerFilter struct{}
+
+func (bannerFilter) CheckSystem() error { return nil }
+
+func (bannerFilter) Process(context *Context, content string, extension string) (string, error) {
+	return "// generated\n" + content, nil
+}
+
+func (f bannerFilter) ProcessWithSourceMap(context *Context, content string, extension string) (string, *SourceMap, error) {
+	filtered, _ := f.Process(context, content, extension)
+	m := mappings{{}}
+	for i := 0; i < strings.Count(content, "\n"); i++ {
+		m = append(m, []segment{{0, 0, i, 0}})
+	}
+	return filtered, &SourceMap{Version: 3, Sources: []string{"input"}, Names: []string{}, Mappings: m.encode()}, nil
+}
+
+func TestBuildSourceMap(t *testing.T) {
+	useFilter(t, "banner", bannerFilter{})
+
+	fs := NewTestFS()
+	fs.File("assets/lib.js", "lib();\n")
+	fs.File("assets/app.js.banner", "//= require lib\n\nfoo();\nbar();\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+	context.Config.SourceMaps = true
+
+	r := &Resolution{}
+	if err := r.Resolve("app.js", context); err != nil {
+		t.Fatal(err)
+	}
+
+	built, err := Build(r, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "/* lib.js */\nlib();\n\n/* app.js */\n// generated\nfoo();\nbar();\n\n//# sourceMappingURL=app.js.map\n"
+	if built != expected {
+		t.Errorf("expected %q, got: %q", expected, built)
+	}
+
+	sm, err := BuildSourceMap(r, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sm.File != "app.js" {
+		t.Errorf("expected file to be %q, got: %q", "app.js", sm.File)
+	}
+	if expected := []string{"lib.js", "app.js.banner"}; !eq(sm.Sources, expected) {
+		t.Errorf("expected sources to be %q, got: %q", expected, sm.Sources)
+	}
+	if sm.SourcesContent[1] != "//= require lib\n\nfoo();\nbar();\n" {
+		t.Errorf("expected sourcesContent to hold the original file, got: %q", sm.SourcesContent[1])
+	}
+	if expected := ";AAAA;;;;ACEA;AACA;"; sm.Mappings != expected {
+		t.Errorf("expected mappings to be %q, got: %q", expected, sm.Mappings)
+	}
+}
+
+func TestSourceMappingURLForStylesheets(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.css", "body {}\n")
+
+	context := NewContext(fs)
+	context.SearchPath("assets")
+	context.Config.SourceMaps = true
+
+	r := &Resolution{}
+	if err := r.Resolve("app.css", context); err != nil {
+		t.Fatal(err)
+	}
+
+	built, err := Build(r, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(built, "\n/*# sourceMappingURL=app.css.map */\n") {
+		t.Errorf("expected a CSS sourceMappingURL comment, got: %q", built)
+	}
+}
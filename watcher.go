@@ -0,0 +1,265 @@
+package monk
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Change describes the files that changed between two polls of a Watcher.
+type Change struct {
+	// The absolute paths of the files and directories that were created,
+	// modified or removed, in lexical order.
+	Paths []string
+
+	// The logical paths of the assets that were removed from the Store as a
+	// result, in lexical order.
+	LogicalPaths []string
+}
+
+// A Watcher polls every file and directory in a Context's search paths, removing
+// the assets affected by any changes from the Context's Store so that they are
+// created again when next looked up. Other components can subscribe to be told
+// about each change, for example to reload a page.
+type Watcher struct {
+	// Called with the error from each poll started by Start that fails. Errors
+	// are ignored if it is nil. It must be set before Start is called.
+	OnError func(error)
+
+	context *Context
+
+	mu          sync.Mutex
+	files       map[string]watchedFile
+	subscribers map[<-chan Change]chan Change
+	stop        chan struct{}
+}
+
+type watchedFile struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+}
+
+// The number of changes buffered for each subscriber. Changes are dropped for
+// subscribers that fall further behind than this.
+const subscriberBuffer = 16
+
+func NewWatcher(context *Context) *Watcher {
+	return &Watcher{context: context, subscribers: make(map[<-chan Change]chan Change)}
+}
+
+// Poll the search paths every interval until Stop is called. Failed polls are
+// reported to OnError.
+func (w *Watcher) Start(interval time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop != nil {
+		return
+	}
+	w.stop = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := w.Poll(); err != nil && w.OnError != nil {
+					w.OnError(err)
+				}
+			}
+		}
+	}(w.stop)
+}
+
+// Stop polling.
+func (w *Watcher) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+}
+
+// Returns a channel that receives each change found from now on.
+func (w *Watcher) Subscribe() <-chan Change {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch := make(chan Change, subscriberBuffer)
+	w.subscribers[ch] = ch
+	return ch
+}
+
+// Stop sending changes to ch, which must have been returned by Subscribe, and
+// close it.
+func (w *Watcher) Unsubscribe(ch <-chan Change) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if subscriber, ok := w.subscribers[ch]; ok {
+		delete(w.subscribers, ch)
+		close(subscriber)
+	}
+}
+
+// Check the search paths for changes once, invalidating the affected assets and
+// notifying subscribers. The first poll only records the files that exist, and
+// returns nil. Nil is also returned if nothing changed.
+func (w *Watcher) Poll() (*Change, error) {
+	files := map[string]watchedFile{}
+	for _, searchPath := range w.context.SearchPaths {
+		if err := w.scan(searchPath, files); err != nil {
+			return nil, err
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	previous := w.files
+	w.files = files
+	if previous == nil {
+		return nil, nil
+	}
+
+	changed := []string{}
+	for name, file := range files {
+		if old, ok := previous[name]; !ok || old != file {
+			changed = append(changed, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := files[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(changed)
+
+	change := Change{Paths: changed, LogicalPaths: w.context.Invalidate(changed...)}
+	for _, subscriber := range w.subscribers {
+		select {
+		case subscriber <- change:
+		default:
+		}
+	}
+	return &change, nil
+}
+
+// Records the state of every file and directory inside dir in files.
+func (w *Watcher) scan(dir string, files map[string]watchedFile) error {
+	infos, err := w.context.fs.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		fullPath := path.Join(dir, info.Name())
+		if !info.IsDir() {
+			files[fullPath] = watchedFile{info.ModTime(), info.Size(), false}
+			continue
+		}
+
+		// Changes inside directories are seen in the files they contain, so only
+		// whether they exist is recorded.
+		files[fullPath] = watchedFile{isDir: true}
+		if err := w.scan(fullPath, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invalidate removes the assets affected by changes to the files or directories at
+// absPaths from the Store, along with every asset that depends on them directly or
+// through other assets, and returns their logical paths in lexical order.
+//
+// An asset is affected by a file if it was created from it, if the file's logical
+// path is that of the asset or one it depends on, or if the file is inside a
+// directory it requires with require_tree or require_directory.
+func (c *Context) Invalidate(absPaths ...string) []string {
+	changed := map[string]bool{}
+	for _, absPath := range absPaths {
+		if searchPath, ok := c.searchPathFor(absPath); ok && absPath != searchPath {
+			changed[logicalPathFor(strings.TrimPrefix(absPath, searchPath+"/"))] = true
+		}
+	}
+
+	affected := func(logicalPath string, asset *Asset) bool {
+		if changed[logicalPath] {
+			return true
+		}
+		for _, absPath := range absPaths {
+			if asset.Path == absPath {
+				return true
+			}
+			for _, tree := range asset.stamp.trees {
+				if strings.HasPrefix(absPath, tree.root+"/") {
+					return true
+				}
+			}
+		}
+		for _, dependency := range asset.DependOn {
+			if changed[dependency] {
+				return true
+			}
+		}
+		return false
+	}
+
+	s := c.shared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Lookups in progress may have read the files before they changed, so the
+	// assets they create must be checked before they are used.
+	s.generation++
+
+	// Changed files are followed to their dependents even if they have not been
+	// looked up themselves.
+	dependents := map[string][]string{}
+	queue := []string{}
+	for logicalPath := range changed {
+		queue = append(queue, logicalPath)
+	}
+	for logicalPath, asset := range c.Store {
+		for _, deps := range [][]string{asset.Dependencies, asset.Stubs, asset.DependOn} {
+			for _, dep := range deps {
+				dependents[dep] = append(dependents[dep], logicalPath)
+			}
+		}
+		if affected(logicalPath, asset) {
+			queue = append(queue, logicalPath)
+		}
+	}
+
+	invalidated := []string{}
+	seen := map[string]bool{}
+	for len(queue) > 0 {
+		logicalPath := queue[0]
+		queue = queue[1:]
+		if seen[logicalPath] {
+			continue
+		}
+		seen[logicalPath] = true
+
+		if _, ok := c.Store[logicalPath]; ok {
+			delete(c.Store, logicalPath)
+			invalidated = append(invalidated, logicalPath)
+		}
+		queue = append(queue, dependents[logicalPath]...)
+	}
+
+	sort.Strings(invalidated)
+	return invalidated
+}
@@ -0,0 +1,166 @@
+package monk
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js", "//= require lib\napp();\n")
+	fs.File("assets/lib.js", "lib();\n")
+	fs.File("assets/tree.js", "//= require_tree ./vendor\n")
+	fs.File("assets/vendor/a.js", "a();\n")
+	fs.File("assets/page.css.tmpl", "body { background: url('{{url \"app.js\"}}'); }\n")
+	fs.File("assets/other.css", "other {}\n")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+	c.Config.Fingerprint = true
+
+	load := func() {
+		for _, logicalPath := range []string{"app.js", "tree.js", "page.css", "other.css"} {
+			r := &Resolution{}
+			if err := r.Resolve(logicalPath, c); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	load()
+
+	w := NewWatcher(c)
+	if change, err := w.Poll(); change != nil || err != nil {
+		t.Fatalf("expected the first poll to only record files, got: %v, %v", change, err)
+	}
+	if change, err := w.Poll(); change != nil || err != nil {
+		t.Fatalf("expected no changes, got: %v, %v", change, err)
+	}
+
+	changes := w.Subscribe()
+	poll := func(expectedPaths []string, expectedLogicalPaths []string) {
+		change, err := w.Poll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if change == nil || !eq(change.Paths, expectedPaths) || !eq(change.LogicalPaths, expectedLogicalPaths) {
+			t.Errorf("expected changes to %q invalidating %q, got: %+v", expectedPaths, expectedLogicalPaths, change)
+			return
+		}
+		for _, logicalPath := range change.LogicalPaths {
+			if _, ok := c.Store[logicalPath]; ok {
+				t.Errorf("expected %q to be removed from the Store", logicalPath)
+			}
+		}
+		select {
+		case notified := <-changes:
+			if !eq(notified.Paths, expectedPaths) {
+				t.Errorf("expected subscribers to be told about %q, got: %q", expectedPaths, notified.Paths)
+			}
+		default:
+			t.Errorf("expected subscribers to be notified")
+		}
+	}
+
+	// Assets that require the changed file, and those that refer to them with the url
+	// helper, are invalidated too.
+	fs.File("assets/lib.js", "lib(1);\n")
+	poll([]string{"assets/lib.js"}, []string{"app.js", "lib.js", "page.css"})
+	if _, ok := c.Store["other.css"]; !ok {
+		t.Errorf("expected unaffected assets to be kept")
+	}
+
+	load()
+	fs.File("assets/vendor/nested/b.js", "b();\n")
+	poll([]string{"assets/vendor/nested", "assets/vendor/nested/b.js"}, []string{"tree.js"})
+
+	load()
+	delete(fs.files, "assets/other.css")
+	poll([]string{"assets/other.css"}, []string{"other.css"})
+
+	w.Unsubscribe(changes)
+	if _, ok := <-changes; ok {
+		t.Errorf("expected Unsubscribe to close the channel")
+	}
+}
+
+func TestWatcherStart(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js", "app();\n")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+
+	w := NewWatcher(c)
+	if _, err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	changes := w.Subscribe()
+
+	// TestFS is not safe for concurrent use, so the file is changed before polling
+	// starts.
+	fs.File("assets/app.js", "app(1);\n")
+	w.Start(time.Millisecond)
+	defer w.Stop()
+
+	select {
+	case change := <-changes:
+		if !eq(change.Paths, []string{"assets/app.js"}) {
+			t.Errorf("unexpected change: %+v", change)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the change to be noticed")
+	}
+}
+
+// A file system whose directories can't be read.
+type unreadableFS struct {
+	*TestFS
+}
+
+func (fs unreadableFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrPermission}
+}
+
+func TestWatcherStartErrors(t *testing.T) {
+	c := NewContext(unreadableFS{NewTestFS()})
+	c.SearchPath("assets")
+
+	errs := make(chan error, 1)
+	w := NewWatcher(c)
+	w.OnError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	w.Start(time.Millisecond)
+	defer w.Stop()
+
+	select {
+	case err := <-errs:
+		if !os.IsPermission(err) {
+			t.Errorf("expected the error from the failed poll, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the failed poll to be reported")
+	}
+}
+
+func TestInvalidateDependentsOfUnloadedFiles(t *testing.T) {
+	fs := NewTestFS()
+	fs.File("assets/app.js", "//= require lib\napp();\n")
+	fs.File("assets/lib.js", "lib();\n")
+
+	c := NewContext(fs)
+	c.SearchPath("assets")
+
+	// Only app.js itself is in the Store, since it has not been resolved.
+	if _, err := c.lookup("app.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	if invalidated := c.Invalidate("assets/lib.js"); !eq(invalidated, []string{"app.js"}) {
+		t.Errorf("expected assets requiring the changed file to be invalidated, got: %q", invalidated)
+	}
+}